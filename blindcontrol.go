@@ -0,0 +1,28 @@
+package sladdfri
+
+// The BlindControl struct holds all settings to control a given Trådfri
+// smart blind, such as FYRTUR or KADRILJ.
+type BlindControl struct {
+	// How far this blind is closed, as a percentage: 0 is fully open and
+	// 100 is fully closed. Read-write.
+	Position float64 `json:"5536"`
+
+	// Numeric identifier of this blind.
+	ID uint32 `json:"9003"`
+}
+
+// The BlindSet struct is used in a request to change a Trådfri smart blind's
+// settings.
+type BlindSet struct {
+	BlindControl []BlindUpdate `json:"15015"`
+}
+
+// A BlindUpdate describes a change to a Trådfri smart blind. Only the fields
+// that are set are sent to the gateway.
+type BlindUpdate struct {
+	// The position to move the blind to, see BlindControl.
+	Position *float64 `json:"5536,omitempty"`
+
+	// Writing any value stops a moving blind.
+	Trigger *int `json:"5523,omitempty"`
+}
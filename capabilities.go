@@ -0,0 +1,114 @@
+package sladdfri
+
+import (
+	"strings"
+)
+
+// The settings a light bulb supports, as a set of flags.
+type Capabilities uint8
+
+const (
+	// The brightness can be changed.
+	CapDim Capabilities = 1 << iota
+
+	// The white spectrum color temperature can be changed, see Mireds.
+	CapColorTemperature
+
+	// The color can be changed in the CIE 1931 xy color space.
+	CapColorXY
+
+	// The color can be changed through hue and saturation.
+	CapColorHueSat
+
+	// Any kind of full color support.
+	CapColor = CapColorXY | CapColorHueSat
+)
+
+// Reports whether all of the given capabilities are in this set.
+func (c Capabilities) Has(other Capabilities) bool {
+	return c&other == other
+}
+
+func (c Capabilities) String() string {
+	var names []string
+	if c.Has(CapDim) {
+		names = append(names, "Dim")
+	}
+	if c.Has(CapColorTemperature) {
+		names = append(names, "Color temperature")
+	}
+	if c.Has(CapColorXY) {
+		names = append(names, "XY")
+	}
+	if c.Has(CapColorHueSat) {
+		names = append(names, "Hue/Sat")
+	}
+	return strings.Join(names, ",")
+}
+
+// The capabilities of IKEA light bulbs by model number, for models whose
+// capabilities cannot be derived from the model number alone.
+var modelCapabilities = map[string]Capabilities{
+	"TRADFRI transformer 10W":      CapDim,
+	"TRADFRI transformer 30W":      CapDim,
+	"TRADFRI Driver 10W":           CapDim,
+	"TRADFRI Driver 30W":           CapDim,
+	"LEPTITER Recessed spot light": CapDim | CapColorTemperature,
+	"GUNNARP panel round":          CapDim | CapColorTemperature,
+	"GUNNARP panel 40*40":          CapDim | CapColorTemperature,
+	"TRADFRI bulb E27 CWS 806lm":   CapDim | CapColorTemperature | CapColor,
+	"TRADFRI bulb E14 CWS 470lm":   CapDim | CapColorTemperature | CapColor,
+	"TRADFRI bulb GU10 CWS 345lm":  CapDim | CapColorTemperature | CapColor,
+}
+
+// The capabilities implied by the spectrum in IKEA model numbers, such as the
+// WS in "TRADFRI bulb E27 WS opal 980lm".
+var spectrumCapabilities = map[string]Capabilities{
+	"W":    CapDim,
+	"WW":   CapDim,
+	"WS":   CapDim | CapColorTemperature,
+	"CWS":  CapDim | CapColor,
+	"C/WS": CapDim | CapColorTemperature | CapColor,
+}
+
+// Returns the settings supported by this device. Only lights have any
+// capabilities. They are looked up by model number, or derived from the
+// spectrum named in the model number. Only if neither is known are they
+// derived from whatever the light currently reports: white spectrum bulbs
+// report x and y for their color temperature, too.
+func (d *Device) Capabilities() Capabilities {
+	if d.Type != Light {
+		return 0
+	}
+
+	caps := CapDim
+	model := d.Device.ModelNumber
+	known := false
+	if modelCaps, ok := modelCapabilities[model]; ok {
+		caps |= modelCaps
+		known = true
+	} else {
+		for _, token := range strings.Fields(model) {
+			if spectrumCaps, ok := spectrumCapabilities[token]; ok {
+				caps |= spectrumCaps
+				known = true
+			}
+		}
+	}
+	if known {
+		return caps
+	}
+
+	for _, entry := range d.LightControl {
+		if entry.Mireds != 0 {
+			caps |= CapColorTemperature
+		}
+		if entry.ColorX != 0 || entry.ColorY != 0 {
+			caps |= CapColorXY
+		}
+		if entry.ColorHue != 0 || entry.ColorSat != 0 {
+			caps |= CapColorHueSat
+		}
+	}
+	return caps
+}
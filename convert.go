@@ -11,6 +11,12 @@ const (
 	DimMin   = 0
 	MiredMin = 250 // 4000K
 	MiredMax = 454 // 2200K
+	XYMin    = 0
+	XYMax    = 65535
+	HueMin   = 0
+	HueMax   = 65535
+	SatMin   = 0
+	SatMax   = 65279
 )
 
 // TODO: use stdlib? https://stackoverflow.com/a/39544897
@@ -0,0 +1,114 @@
+package sladdfri
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Credentials identify a client to the gateway. The gateway only allows a
+// limited number of identities, so credentials should be reused across
+// connections rather than negotiated anew every time.
+type Credentials struct {
+	// The identity the preshared key was negotiated for.
+	Identity string `json:"identity"`
+
+	// The preshared key negotiated with the gateway.
+	PSK string `json:"psk"`
+}
+
+// A CredentialStore persists the credentials negotiated with gateways, so
+// that a Client can reuse them after a restart.
+type CredentialStore interface {
+	// Returns the credentials stored for the given gateway, or nil if
+	// there are none.
+	Load(gateway string) (*Credentials, error)
+
+	// Stores the given credentials for the given gateway, replacing any
+	// previously stored credentials.
+	Save(gateway string, creds Credentials) error
+}
+
+// FileCredentialStore is a CredentialStore keeping the credentials of all
+// gateways in a single JSON file, readable and writable only by its owner.
+type FileCredentialStore struct {
+	// The path of the JSON file.
+	Path string
+
+	// Serialises access to the file.
+	mu sync.Mutex
+}
+
+// Creates a new FileCredentialStore backed by the file at the given path. The
+// file is created on the first call to Save.
+func NewFileCredentialStore(path string) *FileCredentialStore {
+	return &FileCredentialStore{
+		Path: path,
+	}
+}
+
+func (s *FileCredentialStore) Load(gateway string) (*Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	creds, ok := all[gateway]
+	if !ok {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+func (s *FileCredentialStore) Save(gateway string, creds Credentials) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	all[gateway] = creds
+	data, err := json.MarshalIndent(all, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a crash halfway through
+	// does not lose the credentials of all other gateways. Temporary files
+	// are created with 0600 permissions.
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+func (s *FileCredentialStore) read() (map[string]Credentials, error) {
+	all := make(map[string]Credentials)
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return all, nil
+	} else if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &all)
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
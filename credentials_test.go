@@ -0,0 +1,76 @@
+package sladdfri
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zubairhamed/canopus"
+)
+
+func TestFileCredentialStore(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	store := NewFileCredentialStore(path)
+
+	creds, err := store.Load("192.168.1.2")
+	assert.NoError(err)
+	assert.Nil(creds)
+
+	assert.NoError(store.Save("192.168.1.2", Credentials{Identity: "living", PSK: "secret"}))
+	assert.NoError(store.Save("192.168.1.3", Credentials{Identity: "attic", PSK: "hidden"}))
+
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	creds, err = NewFileCredentialStore(path).Load("192.168.1.2")
+	assert.NoError(err)
+	assert.Equal(&Credentials{Identity: "living", PSK: "secret"}, creds)
+}
+
+func TestClientCredentials(t *testing.T) {
+	c := NewClientWithCredentials("192.168.1.2", Credentials{Identity: "living", PSK: "secret"})
+	assert.Equal(t, Credentials{Identity: "living", PSK: "secret"}, c.Credentials())
+}
+
+func TestConnect(t *testing.T) {
+	assert := assert.New(t)
+	store := NewFileCredentialStore(filepath.Join(t.TempDir(), "credentials.json"))
+
+	var dials []Credentials
+	dial := func(ctx context.Context, address, identity, psk string) (Transport, error) {
+		dials = append(dials, Credentials{Identity: identity, PSK: psk})
+		transport := NewMemoryTransport()
+		transport.Handle(canopus.Post, "/15011/9063", func(req Request) *Response {
+			assert.JSONEq(`{"9090":"living"}`, string(req.Payload))
+			return &Response{Code: canopus.CoapCodeCreated, Payload: []byte(`{"9091":"secret"}`)}
+		})
+		return transport, nil
+	}
+
+	// Without stored credentials, a preshared key is negotiated and saved.
+	c := NewClient("192.168.1.2", "gatewaycode")
+	c.CredentialStore = store
+	c.Dial = dial
+	assert.NoError(c.Connect("living"))
+	assert.Equal([]Credentials{
+		{Identity: "Client_identity", PSK: "gatewaycode"},
+		{Identity: "living", PSK: "secret"},
+	}, dials)
+	assert.Equal(Credentials{Identity: "living", PSK: "secret"}, c.Credentials())
+	creds, err := store.Load("192.168.1.2")
+	assert.NoError(err)
+	assert.Equal(&Credentials{Identity: "living", PSK: "secret"}, creds)
+
+	// With stored credentials, the handshake is skipped.
+	dials = nil
+	c = NewClient("192.168.1.2", "gatewaycode")
+	c.CredentialStore = store
+	c.Dial = dial
+	assert.NoError(c.Connect(""))
+	assert.Equal([]Credentials{{Identity: "living", PSK: "secret"}}, dials)
+	assert.Equal(Credentials{Identity: "living", PSK: "secret"}, c.Credentials())
+}
@@ -22,8 +22,20 @@ const (
 	// Any Trådfri light bulb.
 	Light DeviceType = 2
 
+	// The Trådfri control outlet.
+	Outlet DeviceType = 3
+
 	// The Trådfri motion sensor.
 	Sensor DeviceType = 4
+
+	// The Trådfri signal repeater.
+	Repeater DeviceType = 6
+
+	// A Trådfri smart blind, such as FYRTUR or KADRILJ.
+	Blind DeviceType = 7
+
+	// A Trådfri air purifier, such as STARKVIND.
+	AirPurifier DeviceType = 10
 )
 
 func (t DeviceType) String() string {
@@ -34,8 +46,16 @@ func (t DeviceType) String() string {
 		return "Dimmer"
 	case Light:
 		return "Light"
+	case Outlet:
+		return "Outlet"
 	case Sensor:
 		return "Sensor"
+	case Repeater:
+		return "Repeater"
+	case Blind:
+		return "Blind"
+	case AirPurifier:
+		return "Air purifier"
 	default:
 		return "Unknown"
 	}
@@ -100,6 +120,15 @@ type Device struct {
 	// A list of light source controls, according to IPSO 3311. See LightControl.
 	LightControl []LightControl `json:"3311"`
 
+	// A list of power outlet controls, according to IPSO 3312. See PlugControl.
+	PlugControl []PlugControl `json:"3312"`
+
+	// A list of blind controls. See BlindControl.
+	BlindControl []BlindControl `json:"15015"`
+
+	// A list of air purifier controls. See AirPurifierControl.
+	AirPurifierControl []AirPurifierControl `json:"15025"`
+
 	// The application type of this device, see DeviceType. Read-write. Defined in IPSO 3311, 3335, 3342.
 	Type DeviceType `json:"5750"`
 
@@ -118,8 +147,8 @@ type Device struct {
 	//
 	LastSeen int64 `json:"9020"`
 
-	//
-	OtaUpdateState int `json:"9054"`
+	// The state of the firmware update of this device, see OtaUpdateState.
+	OtaUpdateState OtaUpdateState `json:"9054"`
 }
 
 func (d *Device) String() string {
@@ -147,6 +176,28 @@ func (d *Device) String() string {
 			s += fmt.Sprintf("Hue: %d Sat: %d ", entry.ColorHue, entry.ColorSat)
 			s += "\n"
 		}
+	} else if d.Type == Outlet {
+		for count, entry := range d.PlugControl {
+			power := "off"
+			if entry.Power == 1 {
+				power = "on"
+			}
+			s += fmt.Sprintf("Plug Control Set %d, Power: %s\n", count, power)
+		}
+	} else if d.Type == Blind {
+		for count, entry := range d.BlindControl {
+			s += fmt.Sprintf("Blind Control Set %d, Position: %.0f%%\n", count, entry.Position)
+		}
+		s += fmt.Sprintf("Level: %v%%\n", d.Device.BatteryLevel)
+	} else if d.Type == AirPurifier {
+		for count, entry := range d.AirPurifierControl {
+			quality := "unknown"
+			if entry.AirQuality != AirQualityUnknown {
+				quality = fmt.Sprintf("%d µg/m³", entry.AirQuality)
+			}
+			s += fmt.Sprintf("Air Purifier Control Set %d, Mode: %s, Fan speed: %d, PM2.5: %s\n", count, entry.Mode, entry.FanSpeed, quality)
+			s += fmt.Sprintf("Filter remaining: %s, Locked: %d, LEDs off: %d\n", entry.FilterRemaining(), entry.ControlsLocked, entry.LEDsOff)
+		}
 	} else if d.Type == Remote || d.Type == Dimmer {
 		s += fmt.Sprintf("Level: %v%%\n", d.Device.BatteryLevel)
 	}
@@ -0,0 +1,116 @@
+package sladdfri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultConcurrency = 4
+
+// An ItemError describes why a single item could not be fetched while listing
+// the items of a kind, see ListError.
+type ItemError struct {
+	// Numeric identifier of the item.
+	ID uint32
+
+	// Why the item could not be fetched.
+	Err error
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("%d: %v", e.ID, e.Err)
+}
+
+// A ListError is returned by ListDevices, ListGroups, ListMoods and
+// ListSmartTasks when some items could not be fetched. The items that could
+// be fetched are returned alongside it.
+type ListError struct {
+	// The items that could not be fetched, in ID order.
+	Items []ItemError
+}
+
+func (e *ListError) Error() string {
+	items := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		items[i] = item.Error()
+	}
+	return fmt.Sprintf("Fetching %d items failed: %s", len(e.Items), strings.Join(items, "; "))
+}
+
+// Reports whether the error of any item matches the given target.
+func (e *ListError) Is(target error) bool {
+	for _, item := range e.Items {
+		if errors.Is(item.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetches the items with the given identifiers through get, at most
+// c.Concurrency at a time. The items are returned in ID order. Items that
+// cannot be fetched are left out and reported through a *ListError, unless the
+// context is done, in which case only its error is returned.
+func (c *Client) enumerate(ctx context.Context, ids []uint32, get func(context.Context, uint32) (interface{}, error)) ([]interface{}, error) {
+	sorted := make([]uint32, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	workers := c.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(sorted) {
+		workers = len(sorted)
+	}
+
+	results := make([]interface{}, len(sorted))
+	errs := make([]error, len(sorted))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i], errs[i] = get(ctx, sorted[i])
+			}
+		}()
+	}
+
+feed:
+	for i := range sorted {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]interface{}, 0, len(sorted))
+	var failures []ItemError
+	for i, id := range sorted {
+		if errs[i] != nil {
+			failures = append(failures, ItemError{ID: id, Err: errs[i]})
+			continue
+		}
+		items = append(items, results[i])
+	}
+	if failures != nil {
+		return items, &ListError{Items: failures}
+	}
+	return items, nil
+}
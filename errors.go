@@ -0,0 +1,194 @@
+package sladdfri
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zubairhamed/canopus"
+)
+
+// Errors corresponding to the response codes the gateway may answer a request
+// with. Any *CoAPError returned by a Client method matches one of these
+// through errors.Is.
+var (
+	// 4.00 Bad Request.
+	ErrBadRequest = errors.New("Bad request")
+
+	// 4.01 Unauthorized.
+	ErrUnauthorized = errors.New("Unauthorized")
+
+	// 4.03 Forbidden.
+	ErrForbidden = errors.New("Forbidden")
+
+	// 4.04 Not Found.
+	ErrNotFound = errors.New("Not found")
+
+	// 4.05 Method Not Allowed.
+	ErrMethodNotAllowed = errors.New("Method not allowed")
+
+	// Any other 4.xx code.
+	ErrClient = errors.New("Client error")
+
+	// Any 5.xx code.
+	ErrServer = errors.New("Gateway error")
+)
+
+// Errors matched by a *ValidationError, which is returned before a request is
+// sent to the gateway.
+var (
+	// A value is out of its documented range or malformed.
+	ErrInvalid = errors.New("Invalid value")
+
+	// A device is asked to change a setting it does not support, see
+	// Device.Capabilities.
+	ErrUnsupported = errors.New("Unsupported by device")
+)
+
+// A CoAPError is returned when the gateway answers a request with anything
+// other than a 2.xx success code.
+type CoAPError struct {
+	// The method of the failed request.
+	Method canopus.CoapCode
+
+	// The URI of the failed request.
+	URI string
+
+	// The response code returned by the gateway.
+	Code canopus.CoapCode
+}
+
+func (e *CoAPError) Error() string {
+	return fmt.Sprintf("%s %s: %s", methodString(e.Method), e.URI, codeString(e.Code))
+}
+
+// Reports whether the given target is the sentinel error corresponding to
+// this error's response code.
+func (e *CoAPError) Is(target error) bool {
+	switch e.Code {
+	case canopus.CoapCodeBadRequest:
+		return target == ErrBadRequest
+	case canopus.CoapCodeUnauthorized:
+		return target == ErrUnauthorized
+	case canopus.CoapCodeForbidden:
+		return target == ErrForbidden
+	case canopus.CoapCodeNotFound:
+		return target == ErrNotFound
+	case canopus.CoapCodeMethodNotAllowed:
+		return target == ErrMethodNotAllowed
+	}
+	switch codeClass(e.Code) {
+	case 4:
+		return target == ErrClient
+	case 5:
+		return target == ErrServer
+	}
+	return false
+}
+
+// An UnsupportedError is the error of a FieldError for a setting the device
+// does not support. It matches ErrUnsupported through errors.Is.
+type UnsupportedError struct {
+	// The device the update was meant for.
+	Device uint32
+
+	// The capabilities required by the setting but not supported by the
+	// device. Zero if the device is of another type than Required.
+	Missing Capabilities
+
+	// The type of device the request is meant for, if the device is of
+	// another type.
+	Required DeviceType
+
+	// The actual type of the device, if it is not of the Required type.
+	Type DeviceType
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Missing == 0 {
+		return fmt.Sprintf("Device %d is of type %s, not %s", e.Device, e.Type, e.Required)
+	}
+	return fmt.Sprintf("Device %d does not support: %s", e.Device, e.Missing)
+}
+
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// Returns a *CoAPError if the given response does not indicate success.
+func checkResponse(req Request, resp *Response) error {
+	if codeClass(resp.Code) == 2 {
+		return nil
+	}
+	return &CoAPError{
+		Method: req.Method,
+		URI:    req.URI,
+		Code:   resp.Code,
+	}
+}
+
+// Returns the class of the given code, i.e. the 4 in 4.04.
+func codeClass(code canopus.CoapCode) uint8 {
+	return uint8(code) >> 5
+}
+
+func codeString(code canopus.CoapCode) string {
+	s := fmt.Sprintf("%d.%02d", codeClass(code), uint8(code)&0x1f)
+	switch code {
+	case canopus.CoapCodeCreated:
+		s += " Created"
+	case canopus.CoapCodeDeleted:
+		s += " Deleted"
+	case canopus.CoapCodeValid:
+		s += " Valid"
+	case canopus.CoapCodeChanged:
+		s += " Changed"
+	case canopus.CoapCodeContent:
+		s += " Content"
+	case canopus.CoapCodeBadRequest:
+		s += " Bad Request"
+	case canopus.CoapCodeUnauthorized:
+		s += " Unauthorized"
+	case canopus.CoapCodeBadOption:
+		s += " Bad Option"
+	case canopus.CoapCodeForbidden:
+		s += " Forbidden"
+	case canopus.CoapCodeNotFound:
+		s += " Not Found"
+	case canopus.CoapCodeMethodNotAllowed:
+		s += " Method Not Allowed"
+	case canopus.CoapCodeNotAcceptable:
+		s += " Not Acceptable"
+	case canopus.CoapCodePreconditionFailed:
+		s += " Precondition Failed"
+	case canopus.CoapCodeRequestEntityTooLarge:
+		s += " Request Entity Too Large"
+	case canopus.CoapCodeUnsupportedContentFormat:
+		s += " Unsupported Content-Format"
+	case canopus.CoapCodeInternalServerError:
+		s += " Internal Server Error"
+	case canopus.CoapCodeNotImplemented:
+		s += " Not Implemented"
+	case canopus.CoapCodeBadGateway:
+		s += " Bad Gateway"
+	case canopus.CoapCodeServiceUnavailable:
+		s += " Service Unavailable"
+	case canopus.CoapCodeGatewayTimeout:
+		s += " Gateway Timeout"
+	}
+	return s
+}
+
+func methodString(method canopus.CoapCode) string {
+	switch method {
+	case canopus.Get:
+		return "GET"
+	case canopus.Post:
+		return "POST"
+	case canopus.Put:
+		return "PUT"
+	case canopus.Delete:
+		return "DELETE"
+	default:
+		return fmt.Sprintf("%d", method)
+	}
+}
@@ -0,0 +1,274 @@
+package sladdfri
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The state of a firmware update of the gateway or a device. The values below
+// follow the order in which an update progresses; their meaning has not been
+// verified against a gateway.
+type OtaUpdateState int
+
+const (
+	// No update is available.
+	OtaIdle OtaUpdateState = 0
+
+	// An update is available, but has not been downloaded yet.
+	OtaUpdateAvailable OtaUpdateState = 1
+
+	// The update is being downloaded.
+	OtaDownloading OtaUpdateState = 2
+
+	// The update has been downloaded and is ready to be installed.
+	OtaReadyToInstall OtaUpdateState = 3
+
+	// The update is being installed.
+	OtaInstalling OtaUpdateState = 4
+)
+
+func (s OtaUpdateState) String() string {
+	switch s {
+	case OtaIdle:
+		return "Idle"
+	case OtaUpdateAvailable:
+		return "Update available"
+	case OtaDownloading:
+		return "Downloading"
+	case OtaReadyToInstall:
+		return "Ready to install"
+	case OtaInstalling:
+		return "Installing"
+	default:
+		return "Unknown"
+	}
+}
+
+// How urgent an available firmware update of the gateway is.
+type UpdatePriority int
+
+const (
+	NormalUpdate   UpdatePriority = 0
+	CriticalUpdate UpdatePriority = 1
+	RequiredUpdate UpdatePriority = 2
+	ForcedUpdate   UpdatePriority = 5
+)
+
+func (p UpdatePriority) String() string {
+	switch p {
+	case NormalUpdate:
+		return "Normal"
+	case CriticalUpdate:
+		return "Critical"
+	case RequiredUpdate:
+		return "Required"
+	case ForcedUpdate:
+		return "Forced"
+	default:
+		return "Unknown"
+	}
+}
+
+// The data sent to the gateway in a request to check for firmware updates.
+type CheckForUpdatesRequest struct {
+	// Any non-empty value triggers a check.
+	ForceOtaUpdateCheck string `json:"9032"`
+}
+
+// The UpdateStatus struct describes the progress of a firmware update of the
+// gateway.
+type UpdateStatus struct {
+	// The state of the update.
+	State OtaUpdateState
+
+	// The progress of the update, as a percentage.
+	Progress int
+
+	// How urgent the update is.
+	Priority UpdatePriority
+
+	// URL pointing to the release notes of the update.
+	ReleaseNotesURL string
+}
+
+// Makes the gateway check for firmware updates of itself and all devices
+// right away, rather than at its next scheduled check.
+func (c *Client) CheckForUpdates() error {
+	return c.CheckForUpdatesContext(context.Background())
+}
+
+// Like CheckForUpdates, but gives up when the given context is done.
+func (c *Client) CheckForUpdatesContext(ctx context.Context) error {
+	payload := CheckForUpdatesRequest{
+		ForceOtaUpdateCheck: "1",
+	}
+	return c.putRequest(ctx, uriGatewayInfo, payload)
+}
+
+// Watches the progress of the firmware update of the gateway until the given
+// context is done. The current status is sent right away, after which every
+// change is sent. The returned channel is closed once the context is done.
+func (c *Client) WatchUpdateProgress(ctx context.Context) (<-chan UpdateStatus, error) {
+	gateway, err := c.GetGatewayContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := c.SubscribeGatewayContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan UpdateStatus)
+	go func() {
+		defer close(out)
+		defer sub.Cancel()
+
+		last := updateStatus(gateway)
+		select {
+		case out <- last:
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			select {
+			case gateway, ok := <-sub.Updates():
+				if !ok {
+					return
+				}
+				status := updateStatus(gateway)
+				if status == last {
+					continue
+				}
+				last = status
+				select {
+				case out <- status:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func updateStatus(g *Gateway) UpdateStatus {
+	return UpdateStatus{
+		State:           g.OtaUpdateState,
+		Progress:        g.UpdateProgress,
+		Priority:        g.UpdatePriority,
+		ReleaseNotesURL: g.ReleaseNotesURL,
+	}
+}
+
+// A FirmwareEntry describes the firmware of a single device.
+type FirmwareEntry struct {
+	// The device.
+	Device *Device
+
+	// The newest firmware version found among all devices with the same
+	// model number.
+	Latest string
+
+	// Whether the firmware of the device lags behind that of other devices
+	// with the same model number.
+	Outdated bool
+}
+
+// A FirmwareInventory lists the firmware of all devices, see
+// NewFirmwareInventory.
+type FirmwareInventory []FirmwareEntry
+
+// Creates the firmware inventory of the given devices, flagging every device
+// whose firmware version is older than that of another device with the same
+// model number.
+func NewFirmwareInventory(devices []*Device) FirmwareInventory {
+	latest := make(map[string]string)
+	for _, d := range devices {
+		model := d.Device.ModelNumber
+		if current, ok := latest[model]; !ok || compareVersions(d.Device.FirmwareVersion, current) > 0 {
+			latest[model] = d.Device.FirmwareVersion
+		}
+	}
+
+	inventory := make(FirmwareInventory, len(devices))
+	for i, d := range devices {
+		newest := latest[d.Device.ModelNumber]
+		inventory[i] = FirmwareEntry{
+			Device:   d,
+			Latest:   newest,
+			Outdated: compareVersions(d.Device.FirmwareVersion, newest) < 0,
+		}
+	}
+	sort.SliceStable(inventory, func(i, j int) bool {
+		return inventory[i].Device.ID < inventory[j].Device.ID
+	})
+	return inventory
+}
+
+// Returns the entries of the devices with outdated firmware.
+func (inv FirmwareInventory) Outdated() FirmwareInventory {
+	var outdated FirmwareInventory
+	for _, entry := range inv {
+		if entry.Outdated {
+			outdated = append(outdated, entry)
+		}
+	}
+	return outdated
+}
+
+// Lists the firmware of all devices connected to the gateway, see
+// NewFirmwareInventory. If some devices cannot be fetched, the inventory of
+// the others is returned along with a *ListError.
+func (c *Client) GetFirmwareInventory() (FirmwareInventory, error) {
+	return c.GetFirmwareInventoryContext(context.Background())
+}
+
+// Like GetFirmwareInventory, but gives up when the given context is done.
+func (c *Client) GetFirmwareInventoryContext(ctx context.Context) (FirmwareInventory, error) {
+	devices, err := c.ListDevicesContext(ctx)
+	var listErr *ListError
+	if err != nil && !errors.As(err, &listErr) {
+		return nil, err
+	}
+	return NewFirmwareInventory(devices), err
+}
+
+// Compares two dotted version strings such as "1.2.214" component by
+// component, numerically where possible. Returns a negative number if a is
+// older than b, a positive number if it is newer and zero if they are equal.
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		if x == "" {
+			xn, xerr = 0, nil
+		}
+		if y == "" {
+			yn, yerr = 0, nil
+		}
+		if xerr == nil && yerr == nil {
+			if xn != yn {
+				return xn - yn
+			}
+		} else if x != y {
+			return strings.Compare(x, y)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package sladdfri
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zubairhamed/canopus"
+)
+
+func TestCompareVersions(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, compareVersions("1.2.214", "1.2.214"))
+	assert.True(compareVersions("1.2.214", "1.10.0") < 0)
+	assert.True(compareVersions("1.3.009", "1.2.217") > 0)
+	assert.True(compareVersions("2.3", "2.3.1") < 0)
+	assert.Equal(0, compareVersions("2.3", "2.3.0"))
+}
+
+func newFirmwareDevice(id uint32, model, version string) *Device {
+	d := &Device{ID: id}
+	d.Device.ModelNumber = model
+	d.Device.FirmwareVersion = version
+	return d
+}
+
+func TestFirmwareInventory(t *testing.T) {
+	assert := assert.New(t)
+	inventory := NewFirmwareInventory([]*Device{
+		newFirmwareDevice(65539, "TRADFRI bulb E27 WS opal 980lm", "1.2.217"),
+		newFirmwareDevice(65537, "TRADFRI bulb E27 WS opal 980lm", "1.2.214"),
+		newFirmwareDevice(65538, "TRADFRI remote control", "1.2.214"),
+	})
+
+	assert.Len(inventory, 3)
+	assert.Equal(uint32(65537), inventory[0].Device.ID)
+	assert.True(inventory[0].Outdated)
+	assert.Equal("1.2.217", inventory[0].Latest)
+	assert.False(inventory[1].Outdated)
+	assert.False(inventory[2].Outdated)
+
+	outdated := inventory.Outdated()
+	assert.Len(outdated, 1)
+	assert.Equal(uint32(65537), outdated[0].Device.ID)
+}
+
+func TestGetFirmwareInventory(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001", []uint32{65537, 65538}))
+	assert.NoError(transport.SetResource("/15001/65537", map[string]interface{}{
+		"9003": 65537,
+		"3":    map[string]interface{}{"1": "TRADFRI bulb E27 WS opal 980lm", "3": "1.2.214"},
+	}))
+	c := NewClientWithTransport(transport)
+
+	inventory, err := c.GetFirmwareInventory()
+	assert.Len(inventory, 1)
+	assert.Equal(uint32(65537), inventory[0].Device.ID)
+	var listErr *ListError
+	assert.True(errors.As(err, &listErr))
+	assert.Equal(uint32(65538), listErr.Items[0].ID)
+}
+
+func TestCheckForUpdates(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	transport.Accept(canopus.Put, "/15011/15012")
+	c := NewClientWithTransport(transport)
+
+	assert.NoError(c.CheckForUpdates())
+	assert.JSONEq(`{"9032":"1"}`, string(transport.Requests()[0].Payload))
+}
+
+func TestWatchUpdateProgress(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15011/15012", map[string]interface{}{"9054": 1, "9055": 0}))
+	c := NewClientWithTransport(transport)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	statuses, err := c.WatchUpdateProgress(ctx)
+	assert.NoError(err)
+	assert.Equal(UpdateStatus{State: OtaUpdateAvailable}, <-statuses)
+
+	// An unchanged status is not sent again.
+	assert.NoError(transport.Notify("/15011/15012", map[string]interface{}{"9054": 1, "9055": 0}))
+	assert.NoError(transport.Notify("/15011/15012", map[string]interface{}{"9054": 2, "9055": 40}))
+	assert.Equal(UpdateStatus{State: OtaDownloading, Progress: 40}, <-statuses)
+
+	cancel()
+	_, ok := <-statuses
+	assert.False(ok)
+	assert.False(transport.Observed("/15011/15012"))
+}
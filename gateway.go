@@ -1,7 +1,9 @@
 package sladdfri
 
 import (
+	"context"
 	"fmt"
+	"time"
 )
 
 const (
@@ -26,7 +28,8 @@ type Gateway struct {
 	// The current time as a Unix timestamp
 	CurrentTimestamp int64 `json:"9059"`
 
-	// The current time of the gateway in the format YYYY-MM-DDTHH:MM:SS.MMM
+	// The current time of the gateway in the format YYYY-MM-DDTHH:MM:SS.MMM,
+	// see CurrentTime.
 	CurrentTimestampUTC string `json:"9060"`
 
 	// The amount of seconds in which this gateway accepts pairing requests from
@@ -39,24 +42,25 @@ type Gateway struct {
 	// The name of the gateway.
 	Name string `json:"9035"`
 
+	// The daylight saving time rules of the gateway, which determine when its
+	// smart tasks fire. See Client.SetDaylightSaving.
+	DaylightSaving
+
+	// The state of the firmware update of the gateway, see OtaUpdateState.
+	OtaUpdateState OtaUpdateState `json:"9054"`
+
+	// The progress of the firmware update of the gateway, as a percentage.
+	UpdateProgress int `json:"9055"`
+
+	// How urgent the available firmware update is, see UpdatePriority.
+	UpdatePriority UpdatePriority `json:"9066"`
+
 	// All of the following fields have been reverse-engineered through the Android APK file.
 	// Their naming and type matches the Java source code, but their function is unknown. It is
 	// also likely that we may be able to use more precise types (e.g. uint8) for many of these.
 	TimeSource              int    `json:"9071"`
-	OtaUpdateState          int    `json:"9054"`
-	UpdateProgress          int    `json:"9055"`
-	UpdatePriority          int    `json:"9066"`
 	UpdateAcceptedTimestamp int    `json:"9069"`
 	ForceOtaUpdateCheck     string `json:"9032"`
-	DstTimeOffset           int    `json:"9080"`
-	DstStartMonth           int    `json:"9072"`
-	DstStartDay             int    `json:"9073"`
-	DstStartHour            int    `json:"9074"`
-	DstStartMinute          int    `json:"9075"`
-	DstEndMonth             int    `json:"9076"`
-	DstEndDay               int    `json:"9077"`
-	DstEndHour              int    `json:"9078"`
-	DstEndMinute            int    `json:"9079"`
 	GoogleHomePairStatus    int    `json:"9105"`
 	AlexaPairStatus         int    `json:"9093"`
 	CertificateProvisioned  int    `json:"9092"`
@@ -69,3 +73,135 @@ func (g *Gateway) String() string {
 		"Current time: %s\n"+
 		"Commissioning: %d seconds\n", g.ID, g.NTPServer, g.FirmwareVersion, g.CurrentTimestampUTC, g.CommissioningMode)
 }
+
+// The DaylightSaving struct holds the daylight saving time rules of the
+// gateway. Transitions are given in local wall clock time, as read just
+// before the transition.
+type DaylightSaving struct {
+	// The amount of minutes the clock is moved forward during daylight
+	// saving time. A value of 0 disables daylight saving time.
+	DstTimeOffset int `json:"9080"`
+
+	// The month, day, hour and minute at which daylight saving time starts.
+	DstStartMonth  int `json:"9072"`
+	DstStartDay    int `json:"9073"`
+	DstStartHour   int `json:"9074"`
+	DstStartMinute int `json:"9075"`
+
+	// The month, day, hour and minute at which daylight saving time ends.
+	DstEndMonth  int `json:"9076"`
+	DstEndDay    int `json:"9077"`
+	DstEndHour   int `json:"9078"`
+	DstEndMinute int `json:"9079"`
+}
+
+// The data sent to the gateway in a request to change its NTP server.
+type NTPRequest struct {
+	// The NTP server the gateway should use.
+	NTPServer string `json:"9023"`
+}
+
+// The data sent to the gateway in a request to change its commissioning mode.
+type CommissioningModeRequest struct {
+	// The amount of seconds in which the gateway accepts pairing requests.
+	CommissioningMode uint32 `json:"9061"`
+}
+
+// Returns the current time of the gateway as parsed from
+// CurrentTimestampUTC, falling back to CurrentTimestamp when it is empty.
+func (g *Gateway) CurrentTime() (time.Time, error) {
+	if g.CurrentTimestampUTC == "" {
+		return time.Unix(g.CurrentTimestamp, 0).UTC(), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.000", "2006-01-02T15:04:05.000Z07:00"} {
+		t, err := time.ParseInLocation(layout, g.CurrentTimestampUTC, time.UTC)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid gateway time %q", g.CurrentTimestampUTC)
+}
+
+// Computes the daylight saving time rules of the given location in the given
+// year. Locations without daylight saving time yield rules that disable it.
+// The location must not be nil.
+func NewDaylightSaving(loc *time.Location, year int) DaylightSaving {
+	var dst DaylightSaving
+
+	t := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	for {
+		_, end := t.ZoneBounds()
+		if end.IsZero() || end.Year() > year {
+			break
+		}
+		_, before := t.Zone()
+		_, after := end.Zone()
+		// The wall clock time just before the transition.
+		wall := end.UTC().Add(time.Duration(before) * time.Second)
+
+		if after > before {
+			dst.DstTimeOffset = (after - before) / 60
+			dst.DstStartMonth = int(wall.Month())
+			dst.DstStartDay = wall.Day()
+			dst.DstStartHour = wall.Hour()
+			dst.DstStartMinute = wall.Minute()
+		} else if after < before {
+			dst.DstEndMonth = int(wall.Month())
+			dst.DstEndDay = wall.Day()
+			dst.DstEndHour = wall.Hour()
+			dst.DstEndMinute = wall.Minute()
+		}
+		t = end
+	}
+
+	// A single permanent change of offset is no daylight saving time.
+	if dst.DstStartMonth == 0 || dst.DstEndMonth == 0 {
+		return DaylightSaving{}
+	}
+	return dst
+}
+
+// Configures the daylight saving time rules of the gateway to those of the
+// given location in the given year, see NewDaylightSaving.
+func (c *Client) SetDaylightSaving(loc *time.Location, year int) error {
+	return c.SetDaylightSavingContext(context.Background(), loc, year)
+}
+
+// Like SetDaylightSaving, but gives up when the given context is done.
+func (c *Client) SetDaylightSavingContext(ctx context.Context, loc *time.Location, year int) error {
+	var v validator
+	if loc == nil {
+		v.invalid("Location", nil, "must not be nil")
+	}
+	err := v.err()
+	if err != nil {
+		return err
+	}
+
+	return c.putRequest(ctx, uriGatewayInfo, NewDaylightSaving(loc, year))
+}
+
+// Returns how far the clock of the gateway is ahead of the local clock. A
+// negative duration means the gateway lags behind. The round trip time of the
+// request is accounted for, but the result is only accurate to a second when
+// the gateway does not report milliseconds.
+func (c *Client) ClockDrift() (time.Duration, error) {
+	return c.ClockDriftContext(context.Background())
+}
+
+// Like ClockDrift, but gives up when the given context is done.
+func (c *Client) ClockDriftContext(ctx context.Context) (time.Duration, error) {
+	before := time.Now()
+	gateway, err := c.GetGatewayContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	after := time.Now()
+
+	gatewayTime, err := gateway.CurrentTime()
+	if err != nil {
+		return 0, err
+	}
+	local := before.Add(after.Sub(before) / 2)
+	return gatewayTime.Sub(local), nil
+}
@@ -0,0 +1,63 @@
+package sladdfri
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDaylightSaving(t *testing.T) {
+	assert := assert.New(t)
+
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip("No time zone database available")
+	}
+	assert.Equal(DaylightSaving{
+		DstTimeOffset:  60,
+		DstStartMonth:  3,
+		DstStartDay:    29,
+		DstStartHour:   2,
+		DstStartMinute: 0,
+		DstEndMonth:    10,
+		DstEndDay:      25,
+		DstEndHour:     3,
+		DstEndMinute:   0,
+	}, NewDaylightSaving(amsterdam, 2026))
+
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	assert.NoError(err)
+	dst := NewDaylightSaving(sydney, 2026)
+	assert.Equal(60, dst.DstTimeOffset)
+	assert.Equal(10, dst.DstStartMonth)
+	assert.Equal(4, dst.DstEndMonth)
+
+	assert.Equal(DaylightSaving{}, NewDaylightSaving(time.UTC, 2026))
+}
+
+func TestSetDaylightSaving(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	c := NewClientWithTransport(transport)
+	assert.True(errors.Is(c.SetDaylightSaving(nil, 2026), ErrInvalid))
+	assert.Empty(transport.Requests())
+}
+
+func TestGatewayCurrentTime(t *testing.T) {
+	assert := assert.New(t)
+	g := &Gateway{CurrentTimestampUTC: "2026-10-16T12:34:56.789"}
+	now, err := g.CurrentTime()
+	assert.NoError(err)
+	assert.Equal(time.Date(2026, time.October, 16, 12, 34, 56, 789000000, time.UTC), now)
+
+	g = &Gateway{CurrentTimestamp: 1791635696}
+	now, err = g.CurrentTime()
+	assert.NoError(err)
+	assert.Equal(int64(1791635696), now.Unix())
+
+	g = &Gateway{CurrentTimestampUTC: "yesterday"}
+	_, err = g.CurrentTime()
+	assert.Error(err)
+}
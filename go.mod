@@ -0,0 +1,15 @@
+module github.com/Hjdskes/sladdfri
+
+go 1.21
+
+// github.com/zubairhamed/canopus still has to be pinned here, along with its
+// go.sum lines: run `go get github.com/zubairhamed/canopus@master && go mod tidy`
+// with access to the module proxy.
+
+require github.com/stretchr/testify v1.9.0
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -22,6 +22,28 @@ type Group struct {
 	// are not a percentage.
 	Dim uint8 `json:"5851"`
 
+	// The hex color string last set on this group. Read-write. Defined in IPSO 3311, 3335.
+	Color string `json:"5706"`
+
+	// The hue last set on this group, only for RGB bulbs.
+	ColorHue int `json:"5707"`
+
+	// The saturation last set on this group, only for RGB bulbs.
+	ColorSat int `json:"5708"`
+
+	// The x coordinate of the color last set on this group.
+	ColorX int `json:"5709"`
+
+	// The y coordinate of the color last set on this group.
+	ColorY int `json:"5710"`
+
+	// The color temperature in mired last set on this group. Valid values are in the range
+	// [250,454], which corresponds to [4000K,2200K].
+	Mireds int `json:"5711"`
+
+	// The duration of the last transition in tenths of a second.
+	TransitionDuration int `json:"5712"`
+
 	// The name of this group, as given by the user.
 	Name string `json:"9001"`
 
@@ -48,6 +70,22 @@ func (g *Group) String() string {
 	createdAt := time.Unix(g.CreatedAt, 0)
 	s := fmt.Sprintf("ID: %d Name: %q Created: %s\n", g.ID, g.Name, createdAt.Format(time.RFC1123))
 	s += fmt.Sprintf("Power: %d Dim: %d\n", g.Power, g.Dim)
+	if g.Mireds != 0 || g.Color != "" || g.ColorX != 0 || g.ColorY != 0 || g.ColorHue != 0 || g.ColorSat != 0 {
+		s += "Color: "
+		if g.Mireds != 0 {
+			s += fmt.Sprintf("%dK ", MiredToKelvin(g.Mireds))
+		}
+		if g.Color != "" {
+			s += fmt.Sprintf("#%s ", g.Color)
+		}
+		if g.ColorX != 0 || g.ColorY != 0 {
+			s += fmt.Sprintf("X:%d/Y:%d ", g.ColorX, g.ColorY)
+		}
+		if g.ColorHue != 0 || g.ColorSat != 0 {
+			s += fmt.Sprintf("Hue: %d Sat: %d ", g.ColorHue, g.ColorSat)
+		}
+		s += "\n"
+	}
 	s += fmt.Sprintf("Linked devices: %v\n", g.AccessoryLink.LinkedItems.DeviceIDs)
 	return s
 }
@@ -59,3 +97,25 @@ type AddGroupRequest struct {
 	// The name of the group, as given by the user.
 	Name string `json:"9001"`
 }
+
+// The data sent to the gateway in a request to add devices to, or remove
+// devices from, an existing group.
+type GroupMembersRequest struct {
+	// Numeric identifier of the group.
+	GroupID uint32 `json:"9003"`
+
+	//
+	AccessoryLink struct {
+		//
+		LinkedItems struct {
+			// Numeric identifiers of the devices to add or remove.
+			DeviceIDs []uint32 `json:"9003"`
+		} `json:"15002"`
+	} `json:"9018"`
+}
+
+// The data sent to the gateway in a request to rename a group.
+type RenameGroupRequest struct {
+	// The new name of the group.
+	Name string `json:"9001"`
+}
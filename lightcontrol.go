@@ -1,5 +1,10 @@
 package sladdfri
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // The LightControl struct holds all settings to control a given
 // Trådfri light bulb.
 type LightControl struct {
@@ -54,5 +59,129 @@ type LightControl struct {
 // The DeviceSet struct is used in a request to change a Trådfri light
 // bulb's settings.
 type DeviceSet struct {
-	LightControl []LightControl `json:"3311"`
+	LightControl []*LightUpdate `json:"3311"`
+}
+
+// A LightUpdate describes a change to the settings of a Trådfri light bulb or
+// group. Only the settings that have been set through its methods are sent to
+// the gateway; all others are left untouched. The methods return the
+// LightUpdate itself, so that they can be chained:
+//
+//	update := NewLightUpdate().On().Dim(127).Kelvin(2700)
+type LightUpdate struct {
+	color      *string
+	colorHue   *int
+	colorSat   *int
+	colorX     *int
+	colorY     *int
+	mireds     *int
+	transition *int
+	power      *uint8
+	dim        *uint8
+	onTime     *uint32
+}
+
+// The wire format of a LightUpdate.
+type lightUpdatePayload struct {
+	Color              *string `json:"5706,omitempty"`
+	ColorHue           *int    `json:"5707,omitempty"`
+	ColorSat           *int    `json:"5708,omitempty"`
+	ColorX             *int    `json:"5709,omitempty"`
+	ColorY             *int    `json:"5710,omitempty"`
+	Mireds             *int    `json:"5711,omitempty"`
+	TransitionDuration *int    `json:"5712,omitempty"`
+	Power              *uint8  `json:"5850,omitempty"`
+	Dim                *uint8  `json:"5851,omitempty"`
+	OnTime             *uint32 `json:"5852,omitempty"`
+}
+
+// Creates a new LightUpdate that does not change anything yet.
+func NewLightUpdate() *LightUpdate {
+	return &LightUpdate{}
+}
+
+// Switches the light on.
+func (u *LightUpdate) On() *LightUpdate {
+	power := uint8(1)
+	u.power = &power
+	return u
+}
+
+// Switches the light off.
+func (u *LightUpdate) Off() *LightUpdate {
+	power := uint8(0)
+	u.power = &power
+	return u
+}
+
+// Sets the dimmer value in the range [0,254]. See PercentageToDim.
+func (u *LightUpdate) Dim(dim uint8) *LightUpdate {
+	u.dim = &dim
+	return u
+}
+
+// Sets the color temperature in Kelvin, see KelvinToMired.
+func (u *LightUpdate) Kelvin(k int) *LightUpdate {
+	return u.Mireds(KelvinToMired(k))
+}
+
+// Sets the color temperature in mired, in the range [250,454].
+func (u *LightUpdate) Mireds(mireds int) *LightUpdate {
+	u.mireds = &mireds
+	return u
+}
+
+// Sets the color as a hex string, e.g. ColorTempWarm.
+func (u *LightUpdate) Color(hex string) *LightUpdate {
+	u.color = &hex
+	return u
+}
+
+// Sets the color in the CIE 1931 xy color space, see HexRGBToColorXYDim.
+func (u *LightUpdate) XY(x, y int) *LightUpdate {
+	u.colorX = &x
+	u.colorY = &y
+	return u
+}
+
+// Sets the hue, only for RGB bulbs.
+func (u *LightUpdate) Hue(hue int) *LightUpdate {
+	u.colorHue = &hue
+	return u
+}
+
+// Sets the saturation, only for RGB bulbs.
+func (u *LightUpdate) Sat(sat int) *LightUpdate {
+	u.colorSat = &sat
+	return u
+}
+
+// Sets the duration of the transition to the new settings. The gateway
+// works in tenths of a second; shorter durations are truncated.
+func (u *LightUpdate) Transition(d time.Duration) *LightUpdate {
+	tenths := int(d / (100 * time.Millisecond))
+	u.transition = &tenths
+	return u
+}
+
+// Resets the counter of the time the light has been on.
+func (u *LightUpdate) ResetOnTime() *LightUpdate {
+	onTime := uint32(0)
+	u.onTime = &onTime
+	return u
+}
+
+func (u *LightUpdate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lightUpdatePayload{
+		Color:              u.color,
+		ColorHue:           u.colorHue,
+		ColorSat:           u.colorSat,
+		ColorX:             u.colorX,
+		ColorY:             u.colorY,
+		Mireds:             u.mireds,
+		TransitionDuration: u.transition,
+		Power:              u.power,
+		Dim:                u.dim,
+		OnTime:             u.onTime,
+	})
 }
@@ -0,0 +1,121 @@
+package sladdfri
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// The severity of a log entry.
+type LogLevel uint8
+
+const (
+	// Every message exchanged with the gateway.
+	LevelDebug LogLevel = 0
+
+	// Notable events, such as connecting to the gateway.
+	LevelInfo LogLevel = 1
+
+	// Failures the client may recover from, such as a failed request.
+	LevelWarn LogLevel = 2
+
+	// Failures the client cannot recover from.
+	LevelError LogLevel = 3
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// A Field is a key-value pair that adds structure to a log entry, e.g. the
+// method, URI, latency or response code of a request.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// A Logger receives the log entries of a Client. Secrets such as the gateway
+// code and the preshared key are redacted before they reach the Logger.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...Field)
+}
+
+// Creates a Logger that writes the entries of at least the given level to the
+// given *log.Logger, or to the standard logger if it is nil. Entries are
+// formatted as "INFO Connecting to gateway address=192.168.1.2:5684".
+func NewStdLogger(logger *log.Logger, min LogLevel) Logger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &stdLogger{
+		logger: logger,
+		min:    min,
+	}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	min    LogLevel
+}
+
+func (l *stdLogger) Log(level LogLevel, msg string, fields ...Field) {
+	if level < l.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for _, field := range fields {
+		fmt.Fprintf(&b, " %s=%v", field.Key, field.Value)
+	}
+	l.logger.Print(b.String())
+}
+
+const redacted = "[REDACTED]"
+
+// Passes the given entry to the Logger of this client, if any, with all
+// secrets redacted.
+func (c *Client) log(level LogLevel, msg string, fields ...Field) {
+	if c.Logger == nil {
+		return
+	}
+	redact := c.redactor()
+	clean := make([]Field, len(fields))
+	for i, field := range fields {
+		clean[i] = field
+		switch value := field.Value.(type) {
+		case string:
+			clean[i].Value = redact.Replace(value)
+		case []byte:
+			clean[i].Value = redact.Replace(string(value))
+		case error:
+			clean[i].Value = redact.Replace(value.Error())
+		case fmt.Stringer:
+			clean[i].Value = redact.Replace(value.String())
+		}
+	}
+	c.Logger.Log(level, redact.Replace(msg), clean...)
+}
+
+// Returns a replacer blanking out the gateway code and the preshared key.
+func (c *Client) redactor() *strings.Replacer {
+	var secrets []string
+	for _, secret := range []string{c.Key, c.Credentials().PSK} {
+		if secret != "" {
+			secrets = append(secrets, secret, redacted)
+		}
+	}
+	return strings.NewReplacer(secrets...)
+}
@@ -0,0 +1,73 @@
+package sladdfri
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type entry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	entries []entry
+}
+
+func (l *recordingLogger) Log(level LogLevel, msg string, fields ...Field) {
+	e := entry{level, msg, make(map[string]interface{})}
+	for _, field := range fields {
+		e.fields[field.Key] = field.Value
+	}
+	l.entries = append(l.entries, e)
+}
+
+func TestLoggerFields(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15011/15012", map[string]interface{}{
+		"9023": "pool.ntp.org secretcode",
+	}))
+	logger := &recordingLogger{}
+	c := NewClientWithTransport(transport)
+	c.Key = "secretcode"
+	c.Logger = logger
+
+	_, err := c.GetGateway()
+	assert.NoError(err)
+	_, err = c.GetDevice(65537)
+	assert.Error(err)
+
+	assert.Len(logger.entries, 2)
+	ok := logger.entries[0]
+	assert.Equal(LevelDebug, ok.level)
+	assert.Equal("GET", ok.fields["method"])
+	assert.Equal("/15011/15012", ok.fields["uri"])
+	assert.Equal("2.05 Content", ok.fields["code"])
+	assert.Contains(ok.fields, "latency")
+	assert.NotContains(ok.fields["response"], "secretcode")
+	assert.Contains(ok.fields["response"], redacted)
+
+	failed := logger.entries[1]
+	assert.Equal(LevelWarn, failed.level)
+	assert.Equal("4.04 Not Found", failed.fields["code"])
+}
+
+func TestStdLogger(t *testing.T) {
+	assert := assert.New(t)
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+
+	logger.Log(LevelDebug, "Request", Field{"uri", "/15001"})
+	logger.Log(LevelInfo, "Connecting to gateway", Field{"address", "192.168.1.2:5684"})
+	assert.Equal("INFO Connecting to gateway address=192.168.1.2:5684\n", buf.String())
+}
+
+func TestSilentByDefault(t *testing.T) {
+	c := NewClient("192.168.1.2", "secretcode")
+	assert.Nil(t, c.Logger)
+}
@@ -1,6 +1,7 @@
 package sladdfri
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -58,11 +59,86 @@ type AddMoodRequest struct {
 
 	//
 	IsActive uint8 `json:"9058"`
+
+	// The settings of the lights in the new mood, if any.
+	LightControls []MoodLightControl `json:"15013,omitempty"`
+}
+
+// The data sent to the gateway in a request to change an existing mood. Only
+// the fields that are set are changed.
+type UpdateMoodRequest struct {
+	// The new name of the mood.
+	Name string `json:"9001,omitempty"`
+
+	// The new settings of the lights in the mood.
+	LightControls []MoodLightControl `json:"15013,omitempty"`
+}
+
+// The settings of a single light in a mood, as sent to the gateway. Unlike
+// LightControl, this leaves out the read-only fields and any color settings
+// that have not been set.
+type MoodLightControl struct {
+	// Numeric identifier of the bulb.
+	ID uint32 `json:"9003"`
+
+	// Whether the bulb is on or off.
+	Power uint8 `json:"5850"`
+
+	// Dimmer value in the range [0,254].
+	Dim uint8 `json:"5851"`
+
+	// The hex color string of the bulb.
+	Color string `json:"5706,omitempty"`
+
+	// The hue of the bulb, only for RGB bulbs.
+	ColorHue int `json:"5707,omitempty"`
+
+	// The saturation of the bulb, only for RGB bulbs.
+	ColorSat int `json:"5708,omitempty"`
+
+	// The x coordinate of the color of the bulb.
+	ColorX int `json:"5709,omitempty"`
+
+	// The y coordinate of the color of the bulb.
+	ColorY int `json:"5710,omitempty"`
+
+	// The color temperature of the bulb in mired.
+	Mireds int `json:"5711,omitempty"`
+}
+
+func moodLightControls(controls []LightControl) []MoodLightControl {
+	if controls == nil {
+		return nil
+	}
+	settings := make([]MoodLightControl, len(controls))
+	for i, control := range controls {
+		settings[i] = MoodLightControl{
+			ID:       control.ID,
+			Power:    control.Power,
+			Dim:      control.Dim,
+			Color:    control.Color,
+			ColorHue: control.ColorHue,
+			ColorSat: control.ColorSat,
+			ColorX:   control.ColorX,
+			ColorY:   control.ColorY,
+			Mireds:   control.Mireds,
+		}
+	}
+	return settings
+}
+
+// The data sent to the gateway in a request to activate a mood on a group.
+type ActivateMoodRequest struct {
+	// Numeric identifier of the mood to activate.
+	MoodID uint32 `json:"9039"`
+
+	// Whether the bulbs in the group are on or off.
+	Power uint8 `json:"5850"`
 }
 
-func (c *Client) moodParent() (*uint32, error) {
+func (c *Client) moodParent(ctx context.Context) (*uint32, error) {
 	parent := make([]uint32, 2)
-	err := c.getRequest(uriMoods, &parent)
+	err := c.getRequest(ctx, uriMoods, &parent)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,27 @@
+package sladdfri
+
+// The PlugControl struct holds all settings to control a given Trådfri
+// control outlet.
+type PlugControl struct {
+	// Whether this outlet is on or off. Read-write. Defined in IPSO 3312.
+	Power uint8 `json:"5850"`
+
+	// Dimmer value, always either 0 or 254 as outlets cannot dim. Read-only.
+	Dim uint8 `json:"5851"`
+
+	// Numeric identifier of this outlet.
+	ID uint32 `json:"9003"`
+}
+
+// The PlugSet struct is used in a request to change a Trådfri control
+// outlet's settings.
+type PlugSet struct {
+	PlugControl []PlugUpdate `json:"3312"`
+}
+
+// A PlugUpdate describes a change to the settings of a Trådfri control
+// outlet.
+type PlugUpdate struct {
+	// Whether the outlet is switched on or off.
+	Power uint8 `json:"5850"`
+}
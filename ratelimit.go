@@ -0,0 +1,84 @@
+package sladdfri
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateInterval = 100 * time.Millisecond
+	defaultRateBurst    = 10
+)
+
+// A RateLimiter bounds the rate at which messages are sent to the gateway, to
+// stay clear of its flood protection. It is a token bucket: every message
+// takes a token, and tokens are replenished at a fixed rate up to a maximum
+// burst. A RateLimiter may be shared by several clients of the same gateway.
+type RateLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// Creates a new RateLimiter that allows one message every interval, and up to
+// burst messages at once after a quiet period. The bucket starts out full.
+func NewRateLimiter(interval time.Duration, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		interval: interval,
+		burst:    burst,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// Waits until a message may be sent, or until the given context is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil || l.interval <= 0 {
+		return err
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+
+	// Take the token right away, even if it is yet to be replenished, so
+	// that waiting callers are served in order.
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens * float64(l.interval))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	err = sleep(ctx, delay)
+	if err != nil {
+		// The message is not sent after all.
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// Waits for the rate limiter of this client, if any.
+func (c *Client) wait(ctx context.Context) error {
+	if c.RateLimiter == nil {
+		return nil
+	}
+	return c.RateLimiter.Wait(ctx)
+}
@@ -0,0 +1,47 @@
+package sladdfri
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zubairhamed/canopus"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+	limiter := NewRateLimiter(20*time.Millisecond, 2)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.NoError(limiter.Wait(context.Background()))
+	}
+	// The first two messages are sent right away, the next three are
+	// spaced out.
+	elapsed := time.Since(start)
+	assert.True(elapsed >= 60*time.Millisecond, "elapsed %s", elapsed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.True(errors.Is(limiter.Wait(ctx), context.Canceled))
+}
+
+func TestRateLimiterAppliesToRequests(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15011/15012", map[string]interface{}{}))
+	c := NewClientWithTransport(transport)
+	c.RateLimiter = NewRateLimiter(20*time.Millisecond, 1)
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := c.GetGateway()
+		assert.NoError(err)
+	}
+	elapsed := time.Since(start)
+	assert.True(elapsed >= 60*time.Millisecond, "elapsed %s", elapsed)
+	assert.Len(transport.Requests(), 4)
+	assert.Equal(canopus.Get, transport.Requests()[0].Method)
+}
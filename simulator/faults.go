@@ -0,0 +1,385 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/Hjdskes/sladdfri"
+	"github.com/zubairhamed/canopus"
+)
+
+var (
+	errDisconnected = errors.New("Connection closed by the gateway")
+	errDialFailed   = errors.New("Handshake failed")
+)
+
+// A Fault describes how requests matching its method and URI misbehave, see
+// Faults.Inject. Requests are still forwarded to the gateway unless the fault
+// drops them or answers them with a code of its own.
+type Fault struct {
+	// The method of the affected requests, or zero for any method.
+	// Registering an observation counts as a GET.
+	Method canopus.CoapCode
+
+	// The URI of the affected requests as a path.Match pattern, e.g.
+	// "/15001/*", or empty for any URI.
+	URI string
+
+	// How many requests are affected before the fault wears off, or zero
+	// for all of them.
+	Times int
+
+	// How long the request is held up before it is handled.
+	Delay time.Duration
+
+	// Whether the request is never answered, so that the client gives up
+	// once its context is done.
+	Drop bool
+
+	// If non-zero, the code the request is answered with instead of being
+	// forwarded to the gateway, e.g. canopus.CoapCodeServiceUnavailable.
+	Code canopus.CoapCode
+
+	// Whether the connection is closed after the request reaches the
+	// gateway, but before its response reaches the client.
+	Disconnect bool
+}
+
+func (f *Fault) matches(req sladdfri.Request) bool {
+	if f.Method != 0 && f.Method != req.Method {
+		return false
+	}
+	if f.URI == "" {
+		return true
+	}
+	ok, _ := path.Match(f.URI, req.URI)
+	return ok
+}
+
+// Faults injects faults into the transports between a Client and a gateway,
+// simulated or not. Faults can be changed at any time, also while requests
+// are in flight, and apply to all transports created through Wrap and Dial.
+//
+//	faults := simulator.NewFaults()
+//	client.Dial = faults.Dial(gateway.Dial)
+//	faults.Inject(simulator.Fault{URI: "/15001/*", Code: canopus.CoapCodeServiceUnavailable, Times: 1})
+type Faults struct {
+	// Protects all fields below.
+	mu sync.Mutex
+
+	faults      []*Fault
+	failedDials int
+
+	// Flood protection, see Throttle.
+	throttle *throttle
+
+	// The number of notifications to collect before delivering them in
+	// reverse order, by URI pattern.
+	reorder map[string]int
+}
+
+// Creates a new Faults that does not inject any faults yet.
+func NewFaults() *Faults {
+	return &Faults{
+		reorder: make(map[string]int),
+	}
+}
+
+// Adds the given fault. Faults are matched in the order in which they were
+// injected; only the first matching fault applies to a request.
+func (f *Faults) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault)
+}
+
+// Makes the next n dials through Dial fail.
+func (f *Faults) FailDials(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failedDials = n
+}
+
+// Emulates the flood protection of the gateway: requests are allowed one
+// every interval, in bursts of up to burst requests. Requests exceeding the
+// limit are answered with the given code, or dropped if it is zero. A zero
+// interval disables the limit.
+func (f *Faults) Throttle(interval time.Duration, burst int, code canopus.CoapCode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if interval <= 0 {
+		f.throttle = nil
+		return
+	}
+	f.throttle = &throttle{
+		interval: interval,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+		code:     code,
+	}
+}
+
+// Holds back the notifications for URIs matching the given path.Match
+// pattern until window of them have been collected, and then delivers them
+// in reverse order. A window below 2 disables reordering.
+func (f *Faults) Reorder(uri string, window int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if window < 2 {
+		delete(f.reorder, uri)
+		return
+	}
+	f.reorder[uri] = window
+}
+
+// Removes all faults, throttling and reordering.
+func (f *Faults) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+	f.failedDials = 0
+	f.throttle = nil
+	f.reorder = make(map[string]int)
+}
+
+// Returns the fault to apply to the given request, if any.
+func (f *Faults) match(req sladdfri.Request) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.throttle != nil && !f.throttle.allow() {
+		return &Fault{Code: f.throttle.code, Drop: f.throttle.code == 0}
+	}
+	for i, fault := range f.faults {
+		if !fault.matches(req) {
+			continue
+		}
+		applied := *fault
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				f.faults = append(f.faults[:i:i], f.faults[i+1:]...)
+			}
+		}
+		return &applied
+	}
+	return nil
+}
+
+// Returns the reordering window for the given URI, if any.
+func (f *Faults) window(uri string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for pattern, window := range f.reorder {
+		if ok, _ := path.Match(pattern, uri); ok {
+			return window
+		}
+	}
+	return 0
+}
+
+// Wraps the given DialFunc, such that every transport it returns is subject
+// to these faults.
+func (f *Faults) Dial(dial sladdfri.DialFunc) sladdfri.DialFunc {
+	return func(ctx context.Context, address, identity, psk string) (sladdfri.Transport, error) {
+		f.mu.Lock()
+		fail := f.failedDials > 0
+		if fail {
+			f.failedDials--
+		}
+		f.mu.Unlock()
+		if fail {
+			return nil, errDialFailed
+		}
+
+		transport, err := dial(ctx, address, identity, psk)
+		if err != nil {
+			return nil, err
+		}
+		return f.Wrap(transport), nil
+	}
+}
+
+// Wraps the given transport, such that it is subject to these faults.
+func (f *Faults) Wrap(transport sladdfri.Transport) sladdfri.Transport {
+	return &faultTransport{
+		faults:        f,
+		inner:         transport,
+		notifications: make(chan sladdfri.Notification),
+		closed:        make(chan struct{}),
+	}
+}
+
+// A token bucket emulating flood protection, see Faults.Throttle.
+type throttle struct {
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+	code     canopus.CoapCode
+}
+
+func (t *throttle) allow() bool {
+	now := time.Now()
+	t.tokens += float64(now.Sub(t.last)) / float64(t.interval)
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+	if t.tokens < 1 {
+		return false
+	}
+	t.tokens--
+	return true
+}
+
+// A sladdfri.Transport subject to Faults.
+type faultTransport struct {
+	faults *Faults
+	inner  sladdfri.Transport
+
+	notifications chan sladdfri.Notification
+	pumpOnce      sync.Once
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *faultTransport) Send(ctx context.Context, req sladdfri.Request) (*sladdfri.Response, error) {
+	return t.apply(ctx, req, func() (*sladdfri.Response, error) {
+		return t.inner.Send(ctx, req)
+	})
+}
+
+func (t *faultTransport) Observe(ctx context.Context, uri string) error {
+	req := sladdfri.Request{Method: canopus.Get, URI: uri}
+	resp, err := t.apply(ctx, req, func() (*sladdfri.Response, error) {
+		err := t.inner.Observe(ctx, uri)
+		if err != nil {
+			return nil, err
+		}
+		return &sladdfri.Response{Code: canopus.CoapCodeContent}, nil
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Code != canopus.CoapCodeContent {
+		return fmt.Errorf("Observing %s failed with code %d", uri, resp.Code)
+	}
+	return nil
+}
+
+func (t *faultTransport) CancelObserve(ctx context.Context, uri string) error {
+	select {
+	case <-t.closed:
+		return errClosed
+	default:
+	}
+	return t.inner.CancelObserve(ctx, uri)
+}
+
+// Applies the fault matching the given request, if any, around forward.
+func (t *faultTransport) apply(ctx context.Context, req sladdfri.Request, forward func() (*sladdfri.Response, error)) (*sladdfri.Response, error) {
+	select {
+	case <-t.closed:
+		return nil, errClosed
+	default:
+	}
+
+	fault := t.faults.match(req)
+	if fault == nil {
+		return forward()
+	}
+
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.closed:
+			return nil, errClosed
+		}
+	}
+
+	switch {
+	case fault.Drop:
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.closed:
+			return nil, errClosed
+		}
+	case fault.Code != 0:
+		return &sladdfri.Response{Code: fault.Code}, nil
+	case fault.Disconnect:
+		forward()
+		t.Close()
+		return nil, errDisconnected
+	}
+	return forward()
+}
+
+func (t *faultTransport) Notifications() <-chan sladdfri.Notification {
+	t.pumpOnce.Do(func() {
+		go t.pump()
+	})
+	return t.notifications
+}
+
+// Forwards the notifications of the wrapped transport, reordering them as
+// configured, until the transport is closed.
+func (t *faultTransport) pump() {
+	held := make(map[string][]sladdfri.Notification)
+	in := t.inner.Notifications()
+	for {
+		select {
+		case msg := <-in:
+			window := t.faults.window(msg.URI)
+			if window < 2 {
+				if !t.deliver(msg) {
+					return
+				}
+				continue
+			}
+
+			held[msg.URI] = append(held[msg.URI], msg)
+			if len(held[msg.URI]) < window {
+				continue
+			}
+			batch := held[msg.URI]
+			delete(held, msg.URI)
+			for i := len(batch) - 1; i >= 0; i-- {
+				if !t.deliver(batch[i]) {
+					return
+				}
+			}
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+func (t *faultTransport) deliver(msg sladdfri.Notification) bool {
+	select {
+	case t.notifications <- msg:
+		return true
+	case <-t.closed:
+		return false
+	}
+}
+
+func (t *faultTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.inner.Close()
+	})
+	return err
+}
@@ -0,0 +1,147 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/sladdfri"
+	"github.com/stretchr/testify/assert"
+	"github.com/zubairhamed/canopus"
+)
+
+func connectWithFaults(t *testing.T, g *Gateway) (*sladdfri.Client, *Faults) {
+	faults := NewFaults()
+	c := sladdfri.NewClient("localhost", g.Key)
+	c.Dial = faults.Dial(g.Dial)
+	c.RateLimiter = nil
+	assert.NoError(t, c.Connect("integration-test"))
+	return c, faults
+}
+
+func TestFaultCodes(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	id, err := g.AddDevice(newLight("Kitchen"))
+	assert.NoError(err)
+	c, faults := connectWithFaults(t, g)
+
+	faults.Inject(Fault{URI: "/15001/*", Code: canopus.CoapCodeServiceUnavailable, Times: 1})
+	_, err = c.GetDevice(id)
+	assert.True(errors.Is(err, sladdfri.ErrServer))
+	_, err = c.GetDevice(id)
+	assert.NoError(err)
+
+	faults.Inject(Fault{Method: canopus.Put, Code: canopus.CoapCodeForbidden})
+	assert.True(errors.Is(c.SetDevice(id, sladdfri.NewLightUpdate().On()), sladdfri.ErrForbidden))
+	faults.Clear()
+	assert.NoError(c.SetDevice(id, sladdfri.NewLightUpdate().On()))
+}
+
+func TestFaultDropAndDelay(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	c, faults := connectWithFaults(t, g)
+	c.Timeout = 50 * time.Millisecond
+
+	faults.Inject(Fault{URI: "/15011/15012", Drop: true, Times: 1})
+	_, err := c.GetGateway()
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+
+	faults.Inject(Fault{URI: "/15011/15012", Delay: 20 * time.Millisecond, Times: 1})
+	start := time.Now()
+	_, err = c.GetGateway()
+	assert.NoError(err)
+	assert.True(time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestFaultThrottle(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	c, faults := connectWithFaults(t, g)
+
+	faults.Throttle(time.Hour, 2, canopus.CoapCodeServiceUnavailable)
+	_, err := c.GetGateway()
+	assert.NoError(err)
+	_, err = c.GetGateway()
+	assert.NoError(err)
+	_, err = c.GetGateway()
+	assert.True(errors.Is(err, sladdfri.ErrServer))
+}
+
+func TestFaultDisconnect(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	id, err := g.AddDevice(newLight("Kitchen"))
+	assert.NoError(err)
+	c, faults := connectWithFaults(t, g)
+	sub, err := c.SubscribeDevice(id)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := c.Supervise(ctx, sladdfri.SuperviseOptions{
+		HeartbeatInterval: time.Hour,
+		MinBackoff:        time.Millisecond,
+	})
+
+	// The update reaches the gateway, but the client never hears back.
+	faults.Inject(Fault{Method: canopus.Put, Disconnect: true, Times: 1})
+	faults.FailDials(1)
+	assert.Error(c.SetDevice(id, sladdfri.NewLightUpdate().Off()))
+	var device sladdfri.Device
+	assert.NoError(g.Resource("/15001/65536", &device))
+	assert.Equal(uint8(0), device.LightControl[0].Power)
+
+	var seen []sladdfri.ConnectionState
+	for state := range states {
+		seen = append(seen, state)
+		if state == sladdfri.Connected {
+			break
+		}
+	}
+	assert.Equal([]sladdfri.ConnectionState{
+		sladdfri.Disconnected, sladdfri.Connecting, sladdfri.Connecting, sladdfri.Connected,
+	}, seen)
+
+	// The subscription survives the reconnection. The notification for the
+	// interrupted update may or may not have made it before the connection
+	// was closed.
+	assert.NoError(c.SetDevice(id, sladdfri.NewLightUpdate().On()))
+	for {
+		select {
+		case update := <-sub.Updates():
+			if update.LightControl[0].Power == 1 {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("No notification received")
+		}
+	}
+}
+
+func TestFaultReorder(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	id, err := g.AddDevice(newLight("Kitchen"))
+	assert.NoError(err)
+	c, faults := connectWithFaults(t, g)
+	sub, err := c.SubscribeDevice(id)
+	assert.NoError(err)
+
+	faults.Reorder("/15001/*", 2)
+	assert.NoError(c.SetDevice(id, sladdfri.NewLightUpdate().Dim(10)))
+	assert.NoError(c.SetDevice(id, sladdfri.NewLightUpdate().Dim(20)))
+
+	var dims []uint8
+	for len(dims) < 2 {
+		select {
+		case update := <-sub.Updates():
+			dims = append(dims, update.LightControl[0].Dim)
+		case <-time.After(time.Second):
+			t.Fatal("No notification received")
+		}
+	}
+	assert.Equal([]uint8{20, 10}, dims)
+}
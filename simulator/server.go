@@ -0,0 +1,176 @@
+package simulator
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Hjdskes/sladdfri"
+	"github.com/zubairhamed/canopus"
+)
+
+// How long Serve waits for the server to start.
+const startTimeout = 5 * time.Second
+
+// The routes served by a Server. All requests are answered by
+// Gateway.Handle, which dispatches on the full URI.
+var routes = []string{
+	"/15001",
+	"/15001/:id",
+	"/15004",
+	"/15004/:id",
+	"/15005",
+	"/15005/:parent",
+	"/15005/:parent/:id",
+	"/15010",
+	"/15010/:id",
+	"/15011/:id",
+}
+
+// A Server serves a simulated gateway over CoAP+DTLS-PSK on localhost, so
+// that a Client can connect to it like to a real gateway:
+//
+//	server, err := simulator.Serve(simulator.New("gatewaycode"))
+//	...
+//	defer server.Close()
+//	client := sladdfri.NewClient(server.Addr, "gatewaycode")
+//	err = client.Connect("integration-test")
+type Server struct {
+	// The simulated gateway.
+	Gateway *Gateway
+
+	// The address the server listens on, e.g. "127.0.0.1:49152".
+	Addr string
+
+	server   canopus.CoapServer
+	listener *listener
+
+	// Protects observed.
+	mu sync.Mutex
+
+	// The observed resources by URI, under the name the observing client
+	// used for them.
+	observed map[string]string
+
+	closeOnce sync.Once
+}
+
+// Serves the given gateway on a free UDP port of localhost, until Close is
+// called.
+func Serve(g *Gateway) (*Server, error) {
+	addr, err := freeAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Gateway:  g,
+		Addr:     addr,
+		server:   canopus.NewServer(),
+		observed: make(map[string]string),
+	}
+	for _, route := range routes {
+		s.server.Get(route, s.serve)
+		s.server.Put(route, s.serve)
+		s.server.Post(route, s.serve)
+		s.server.Delete(route, s.serve)
+	}
+	s.server.HandlePSK(func(identity string) []byte {
+		key, ok := g.keyFor(identity)
+		if !ok {
+			return nil
+		}
+		return []byte(key)
+	})
+	s.server.OnObserve(func(resource string, msg canopus.Message) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		uri := normalize(resource)
+		if _, ok := s.observed[uri]; !ok {
+			s.observed[uri] = resource
+			g.observe(uri)
+		}
+	})
+	s.server.OnObserveCancel(func(resource string, msg canopus.Message) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		uri := normalize(resource)
+		if _, ok := s.observed[uri]; ok {
+			delete(s.observed, uri)
+			g.cancelObserve(uri)
+		}
+	})
+	s.listener = g.listen(s.notify)
+
+	started := make(chan struct{})
+	var startOnce sync.Once
+	s.server.OnStart(func(canopus.CoapServer) {
+		startOnce.Do(func() {
+			close(started)
+		})
+	})
+	go s.server.ListenAndServeDTLS(addr)
+
+	select {
+	case <-started:
+		return s, nil
+	case <-time.After(startTimeout):
+		s.Close()
+		return nil, errors.New("Server did not start")
+	}
+}
+
+// Stops serving the gateway.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		s.Gateway.unlisten(s.listener)
+		s.server.Stop()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for uri := range s.observed {
+			s.Gateway.cancelObserve(uri)
+		}
+		s.observed = nil
+	})
+	return nil
+}
+
+func (s *Server) serve(req canopus.Request) canopus.Response {
+	msg := req.GetMessage()
+	r := sladdfri.Request{
+		Method: msg.GetCode(),
+		URI:    msg.GetURIPath(),
+	}
+	if payload := msg.GetPayload(); payload != nil {
+		r.Payload = payload.GetBytes()
+	}
+
+	resp := s.Gateway.Handle(r)
+	reply := canopus.NewMessage(canopus.MessageAcknowledgment, resp.Code, msg.GetMessageId())
+	reply.SetToken(msg.GetToken())
+	if resp.Payload != nil {
+		reply.SetPayload(canopus.NewBytesPayload(resp.Payload))
+	}
+	return canopus.NewResponse(reply, nil)
+}
+
+func (s *Server) notify(uri string, payload []byte) {
+	s.mu.Lock()
+	resource, ok := s.observed[uri]
+	s.mu.Unlock()
+	if ok {
+		s.server.NotifyChange(resource, string(payload), false)
+	}
+}
+
+// Returns an address on localhost with a UDP port that is free right now.
+func freeAddress() (string, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String(), nil
+}
@@ -0,0 +1,664 @@
+// Package simulator provides a simulated Trådfri gateway for integration
+// tests. A Gateway keeps the resource tree of a gateway in memory and serves
+// it either over CoAP+DTLS-PSK on localhost, see Serve, or to a Client in the
+// same process, see Gateway.Dial.
+//
+// Tests seed the gateway with devices, groups, moods and smart tasks, run the
+// Client against it and inspect the requests the gateway received as well as
+// its resulting state. Faults makes the connection to the gateway misbehave,
+// to exercise the error handling of the Client.
+package simulator
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hjdskes/sladdfri"
+	"github.com/zubairhamed/canopus"
+)
+
+const (
+	uriDevices      = "/15001"
+	uriGroups       = "/15004"
+	uriGroupAdd     = "/15004/add"
+	uriGroupRemove  = "/15004/remove"
+	uriMoods        = "/15005"
+	uriSmartTasks   = "/15010"
+	uriGatewayInfo  = "/15011/15012"
+	uriGatewayIdent = "/15011/9063"
+	uriReboot       = "/15011/9030"
+	uriFactoryReset = "/15011/9031"
+
+	// The identity used to negotiate a preshared key with the gateway code.
+	preauthIdentity = "Client_identity"
+
+	// The identifiers of the first device, group, mood and smart task, as
+	// handed out by a real gateway.
+	firstDeviceID    = 65536
+	firstGroupID     = 131072
+	firstMoodID      = 196608
+	firstSmartTaskID = 317440
+
+	// The group all moods are stored under.
+	moodParent = firstGroupID
+
+	// The layout of the current time reported by a real gateway.
+	timeLayout = "2006-01-02T15:04:05.000"
+)
+
+// The settings of a group or mood that are applied to its lights.
+var lightKeys = []string{"5706", "5707", "5708", "5709", "5710", "5711", "5712", "5850", "5851"}
+
+// A Gateway simulates a Trådfri gateway. It is safe for concurrent use.
+type Gateway struct {
+	// The gateway code, used to negotiate preshared keys.
+	Key string
+
+	// Protects all fields below.
+	mu sync.Mutex
+
+	// The leaf resources by URI, as decoded JSON.
+	resources map[string]interface{}
+
+	// The preshared keys handed out, by identity.
+	psks map[string]string
+
+	// The next identifier to hand out, by collection URI.
+	nextID map[string]uint32
+
+	// The number of observers, by URI.
+	observers map[string]int
+
+	requests  []sladdfri.Request
+	listeners map[*listener]struct{}
+
+	// Serialises the delivery of notifications, so that they are
+	// delivered in the order of the changes.
+	publishMu sync.Mutex
+}
+
+// A listener is told about every change to a resource.
+type listener struct {
+	notify func(uri string, payload []byte)
+}
+
+// Creates a new simulated gateway that is protected by the given gateway
+// code. It starts out without any devices, groups, moods or smart tasks.
+func New(key string) *Gateway {
+	g := &Gateway{
+		Key:       key,
+		psks:      make(map[string]string),
+		observers: make(map[string]int),
+		listeners: make(map[*listener]struct{}),
+	}
+	g.reset()
+	return g
+}
+
+// Brings the gateway back to its initial state, keeping the preshared keys
+// handed out.
+func (g *Gateway) reset() {
+	g.resources = map[string]interface{}{
+		uriGatewayInfo: map[string]interface{}{
+			"9081": "7e0000000000000a",
+			"9023": "pool.ntp.org",
+			"9029": "1.19.32",
+			"9035": "Simulator",
+			"9054": 0,
+			"9055": 0,
+			"9061": 0,
+		},
+	}
+	g.nextID = map[string]uint32{
+		uriDevices: firstDeviceID,
+		uriGroups:  firstGroupID + 1,
+		fmt.Sprintf("%s/%d", uriMoods, moodParent): firstMoodID,
+		uriSmartTasks: firstSmartTaskID,
+	}
+}
+
+// Adds the given device to the gateway, returning its identifier. A zero ID
+// is replaced by the next free identifier.
+func (g *Gateway) AddDevice(device *sladdfri.Device) (uint32, error) {
+	return g.add(uriDevices, device)
+}
+
+// Adds the given group to the gateway, returning its identifier. A zero ID is
+// replaced by the next free identifier.
+func (g *Gateway) AddGroup(group *sladdfri.Group) (uint32, error) {
+	return g.add(uriGroups, group)
+}
+
+// Adds the given mood to the gateway, returning its identifier. A zero ID is
+// replaced by the next free identifier.
+func (g *Gateway) AddMood(mood *sladdfri.Mood) (uint32, error) {
+	return g.add(fmt.Sprintf("%s/%d", uriMoods, moodParent), mood)
+}
+
+// Adds the given smart task to the gateway, returning its identifier. A zero
+// ID is replaced by the next free identifier.
+func (g *Gateway) AddSmartTask(task *sladdfri.SmartTask) (uint32, error) {
+	return g.add(uriSmartTasks, task)
+}
+
+func (g *Gateway) add(collection string, v interface{}) (uint32, error) {
+	object, err := decode(v)
+	if err != nil {
+		return 0, err
+	}
+
+	g.mu.Lock()
+	uri, id := g.create(collection, object)
+	g.mu.Unlock()
+
+	g.publish(uri, collection)
+	return id, nil
+}
+
+// Stores the given object in the given collection, handing out an identifier
+// unless it has one. Must be called with g.mu held.
+func (g *Gateway) create(collection string, object map[string]interface{}) (string, uint32) {
+	id := uint32(number(object["9003"]))
+	if id == 0 {
+		id = g.nextID[collection]
+	}
+	if id >= g.nextID[collection] {
+		g.nextID[collection] = id + 1
+	}
+	object["9003"] = id
+	if number(object["9002"]) == 0 {
+		object["9002"] = time.Now().Unix()
+	}
+
+	uri := fmt.Sprintf("%s/%d", collection, id)
+	g.resources[uri] = object
+	return uri, id
+}
+
+// Replaces the resource at the given URI by the JSON encoding of the given
+// value, notifying its observers.
+func (g *Gateway) SetResource(uri string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	err = json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	g.resources[uri] = value
+	g.mu.Unlock()
+
+	g.publish(uri)
+	return nil
+}
+
+// Decodes the current state of the resource at the given URI into out.
+func (g *Gateway) Resource(uri string, out interface{}) error {
+	g.mu.Lock()
+	data, ok := g.get(uri)
+	g.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("No resource at %s", uri)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Returns all requests received so far, in order.
+func (g *Gateway) Requests() []sladdfri.Request {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	requests := make([]sladdfri.Request, len(g.requests))
+	copy(requests, g.requests)
+	return requests
+}
+
+// Reports whether anyone is observing the given URI.
+func (g *Gateway) Observed(uri string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.observers[normalize(uri)] > 0
+}
+
+// Returns the preshared key handed out to the given identity, if any.
+func (g *Gateway) PSK(identity string) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	psk, ok := g.psks[identity]
+	return psk, ok
+}
+
+// Returns the key a client using the given identity must use, or false if the
+// identity is unknown.
+func (g *Gateway) keyFor(identity string) (string, bool) {
+	if identity == preauthIdentity {
+		return g.Key, true
+	}
+	return g.PSK(identity)
+}
+
+func (g *Gateway) observe(uri string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.observers[normalize(uri)]++
+}
+
+func (g *Gateway) cancelObserve(uri string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	uri = normalize(uri)
+	if g.observers[uri] > 0 {
+		g.observers[uri]--
+	}
+}
+
+func (g *Gateway) listen(notify func(uri string, payload []byte)) *listener {
+	l := &listener{notify}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.listeners[l] = struct{}{}
+	return l
+}
+
+func (g *Gateway) unlisten(l *listener) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.listeners, l)
+}
+
+// Tells all listeners about the current state of the given resources.
+func (g *Gateway) publish(uris ...string) {
+	g.publishMu.Lock()
+	defer g.publishMu.Unlock()
+
+	for _, uri := range uris {
+		g.mu.Lock()
+		payload, ok := g.get(uri)
+		listeners := make([]*listener, 0, len(g.listeners))
+		for l := range g.listeners {
+			listeners = append(listeners, l)
+		}
+		g.mu.Unlock()
+		if !ok {
+			continue
+		}
+		for _, l := range listeners {
+			l.notify(uri, payload)
+		}
+	}
+}
+
+// Answers the given request, updating the state of the gateway accordingly
+// and notifying the observers of all changed resources.
+func (g *Gateway) Handle(req sladdfri.Request) *sladdfri.Response {
+	req.URI = normalize(req.URI)
+
+	g.mu.Lock()
+	g.requests = append(g.requests, req)
+	resp, changed := g.handle(req)
+	g.mu.Unlock()
+
+	g.publish(changed...)
+	return resp
+}
+
+// Must be called with g.mu held. Returns the response and the URIs of the
+// changed resources.
+func (g *Gateway) handle(req sladdfri.Request) (*sladdfri.Response, []string) {
+	var payload map[string]interface{}
+	if len(req.Payload) > 0 {
+		err := json.Unmarshal(req.Payload, &payload)
+		if err != nil {
+			return respond(canopus.CoapCodeBadRequest, nil), nil
+		}
+	}
+
+	switch req.Method {
+	case canopus.Get:
+		data, ok := g.get(req.URI)
+		if !ok {
+			return respond(canopus.CoapCodeNotFound, nil), nil
+		}
+		return respond(canopus.CoapCodeContent, data), nil
+
+	case canopus.Put:
+		switch req.URI {
+		case uriGroupAdd, uriGroupRemove:
+			return g.changeGroup(req.URI == uriGroupAdd, payload)
+		}
+		current, ok := g.resources[req.URI].(map[string]interface{})
+		if !ok {
+			return respond(canopus.CoapCodeNotFound, nil), nil
+		}
+		merge(current, payload)
+		changed := []string{req.URI}
+		if strings.HasPrefix(req.URI, uriGroups+"/") {
+			changed = append(changed, g.applyGroup(current, payload)...)
+		}
+		return respond(canopus.CoapCodeChanged, nil), changed
+
+	case canopus.Post:
+		switch {
+		case req.URI == uriGatewayIdent:
+			return g.negotiatePSK(payload)
+		case req.URI == uriReboot:
+			return respond(canopus.CoapCodeChanged, nil), nil
+		case req.URI == uriFactoryReset:
+			g.reset()
+			return respond(canopus.CoapCodeChanged, nil), nil
+		case req.URI == uriSmartTasks, req.URI == fmt.Sprintf("%s/%d", uriMoods, moodParent):
+			if payload == nil {
+				payload = make(map[string]interface{})
+			}
+			delete(payload, "9003")
+			uri, _ := g.create(req.URI, payload)
+			return respond(canopus.CoapCodeCreated, nil), []string{uri, req.URI}
+		}
+		return respond(canopus.CoapCodeMethodNotAllowed, nil), nil
+
+	case canopus.Delete:
+		if _, ok := g.resources[req.URI]; !ok || req.URI == uriGatewayInfo {
+			return respond(canopus.CoapCodeNotFound, nil), nil
+		}
+		delete(g.resources, req.URI)
+		return respond(canopus.CoapCodeDeleted, nil), []string{parent(req.URI)}
+	}
+	return respond(canopus.CoapCodeMethodNotAllowed, nil), nil
+}
+
+// Returns the JSON encoding of the resource or collection at the given URI.
+// Must be called with g.mu held.
+func (g *Gateway) get(uri string) ([]byte, bool) {
+	uri = normalize(uri)
+	if uri == uriMoods {
+		data, err := json.Marshal([]uint32{moodParent})
+		return data, err == nil
+	}
+	if value, ok := g.resources[uri]; ok {
+		if uri == uriGatewayInfo {
+			info := value.(map[string]interface{})
+			now := time.Now()
+			info["9059"] = now.Unix()
+			info["9060"] = now.UTC().Format(timeLayout)
+		}
+		data, err := json.Marshal(value)
+		return data, err == nil
+	}
+	if _, ok := g.nextID[uri]; ok {
+		data, err := json.Marshal(g.children(uri))
+		return data, err == nil
+	}
+	return nil, false
+}
+
+// Returns the sorted identifiers of the resources in the given collection.
+// Must be called with g.mu held.
+func (g *Gateway) children(collection string) []uint32 {
+	ids := []uint32{}
+	for uri := range g.resources {
+		if parent(uri) != collection {
+			continue
+		}
+		id, err := strconv.ParseUint(uri[len(collection)+1:], 10, 32)
+		if err == nil {
+			ids = append(ids, uint32(id))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// Handles both the creation of groups and changes to their members, which
+// share URIs. Must be called with g.mu held.
+func (g *Gateway) changeGroup(add bool, payload map[string]interface{}) (*sladdfri.Response, []string) {
+	if _, ok := payload["9018"]; !ok {
+		if !add {
+			return respond(canopus.CoapCodeBadRequest, nil), nil
+		}
+		group := map[string]interface{}{
+			"9001": payload["9001"],
+			"5850": 0,
+			"5851": 0,
+			"9039": 0,
+		}
+		setMembers(group, numbers(payload["9003"]))
+		uri, _ := g.create(uriGroups, group)
+		return respond(canopus.CoapCodeChanged, nil), []string{uri, uriGroups}
+	}
+
+	uri := fmt.Sprintf("%s/%d", uriGroups, uint32(number(payload["9003"])))
+	group, ok := g.resources[uri].(map[string]interface{})
+	if !ok {
+		return respond(canopus.CoapCodeNotFound, nil), nil
+	}
+	members := make(map[uint32]bool)
+	for _, id := range groupMembers(group) {
+		members[id] = true
+	}
+	for _, id := range groupMembers(payload) {
+		members[id] = add
+	}
+	var result []uint32
+	for id, member := range members {
+		if member {
+			result = append(result, id)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	setMembers(group, result)
+	return respond(canopus.CoapCodeChanged, nil), []string{uri}
+}
+
+// Applies the light settings and the mood activated through the given payload
+// to the lights in the given group, returning the URIs of the changed
+// devices. Must be called with g.mu held.
+func (g *Gateway) applyGroup(group, payload map[string]interface{}) []string {
+	settings := make(map[uint32]map[string]interface{})
+	common := pick(payload, lightKeys)
+	for _, id := range groupMembers(group) {
+		settings[id] = common
+	}
+
+	if moodID, ok := payload["9039"]; ok {
+		uri := fmt.Sprintf("%s/%d/%d", uriMoods, moodParent, uint32(number(moodID)))
+		if mood, ok := g.resources[uri].(map[string]interface{}); ok {
+			lights, _ := mood["15013"].([]interface{})
+			for _, light := range lights {
+				light, ok := light.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				id := uint32(number(light["9003"]))
+				if _, ok := settings[id]; ok {
+					s := pick(light, lightKeys)
+					merge(s, common)
+					settings[id] = s
+				}
+			}
+		}
+	}
+
+	var changed []string
+	for _, id := range groupMembers(group) {
+		if len(settings[id]) == 0 {
+			continue
+		}
+		uri := fmt.Sprintf("%s/%d", uriDevices, id)
+		device, ok := g.resources[uri].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		controls, ok := device["3311"].([]interface{})
+		if !ok || len(controls) == 0 {
+			continue
+		}
+		merge(device, map[string]interface{}{
+			"3311": []interface{}{settings[id]},
+		})
+		changed = append(changed, uri)
+	}
+	return changed
+}
+
+// Hands out a preshared key for the identity in the given payload. Must be
+// called with g.mu held.
+func (g *Gateway) negotiatePSK(payload map[string]interface{}) (*sladdfri.Response, []string) {
+	identity, _ := payload["9090"].(string)
+	if identity == "" {
+		return respond(canopus.CoapCodeBadRequest, nil), nil
+	}
+	psk, ok := g.psks[identity]
+	if !ok {
+		psk = randomKey()
+		g.psks[identity] = psk
+	}
+	data, _ := json.Marshal(map[string]string{
+		"9091": psk,
+		"9029": "1.19.32",
+	})
+	return respond(canopus.CoapCodeCreated, data), nil
+}
+
+func respond(code canopus.CoapCode, payload []byte) *sladdfri.Response {
+	return &sladdfri.Response{Code: code, Payload: payload}
+}
+
+// Converts the given value into its decoded JSON object.
+func decode(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	err = json.Unmarshal(data, &object)
+	return object, err
+}
+
+// Merges src into dst the way the gateway merges a PUT into a resource:
+// objects are merged key by key, lists of objects element by element, and
+// everything else is replaced.
+func merge(dst, src map[string]interface{}) {
+	for key, value := range src {
+		dst[key] = mergeValue(dst[key], value)
+	}
+}
+
+func mergeValue(dst, src interface{}) interface{} {
+	switch s := src.(type) {
+	case map[string]interface{}:
+		d, ok := dst.(map[string]interface{})
+		if !ok {
+			return s
+		}
+		merge(d, s)
+		return d
+	case []interface{}:
+		d, ok := dst.([]interface{})
+		if !ok {
+			return s
+		}
+		for _, element := range s {
+			if _, ok := element.(map[string]interface{}); !ok {
+				return s
+			}
+		}
+		for i, element := range s {
+			if i < len(d) {
+				d[i] = mergeValue(d[i], element)
+			} else {
+				d = append(d, element)
+			}
+		}
+		return d
+	}
+	return src
+}
+
+// Returns a copy of the given keys of the given object.
+func pick(object map[string]interface{}, keys []string) map[string]interface{} {
+	picked := make(map[string]interface{})
+	for _, key := range keys {
+		if value, ok := object[key]; ok {
+			picked[key] = value
+		}
+	}
+	return picked
+}
+
+// Returns the device identifiers of the given group.
+func groupMembers(group map[string]interface{}) []uint32 {
+	members, _ := group["9018"].(map[string]interface{})
+	devices, _ := members["15002"].(map[string]interface{})
+	return numbers(devices["9003"])
+}
+
+func setMembers(group map[string]interface{}, ids []uint32) {
+	list := make([]interface{}, len(ids))
+	for i, id := range ids {
+		list[i] = id
+	}
+	group["9018"] = map[string]interface{}{
+		"15002": map[string]interface{}{
+			"9003": list,
+		},
+	}
+}
+
+func number(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case uint32:
+		return float64(n)
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
+
+func numbers(v interface{}) []uint32 {
+	list, _ := v.([]interface{})
+	ids := make([]uint32, 0, len(list))
+	for _, element := range list {
+		ids = append(ids, uint32(number(element)))
+	}
+	return ids
+}
+
+// Returns the URI of the collection containing the given URI.
+func parent(uri string) string {
+	i := strings.LastIndex(uri, "/")
+	if i <= 0 {
+		return ""
+	}
+	return uri[:i]
+}
+
+func normalize(uri string) string {
+	return "/" + strings.Trim(uri, "/")
+}
+
+func randomKey() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
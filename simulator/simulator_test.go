@@ -0,0 +1,157 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Hjdskes/sladdfri"
+	"github.com/stretchr/testify/assert"
+	"github.com/zubairhamed/canopus"
+)
+
+func newLight(name string) *sladdfri.Device {
+	light := &sladdfri.Device{
+		Name:         name,
+		Type:         sladdfri.Light,
+		LightControl: []sladdfri.LightControl{{Dim: 254, Mireds: 370}},
+	}
+	light.Device.ModelNumber = "TRADFRI bulb E27 WS opal 980lm"
+	return light
+}
+
+func connect(t *testing.T, g *Gateway) *sladdfri.Client {
+	c := sladdfri.NewClient("localhost", g.Key)
+	c.Dial = g.Dial
+	c.RateLimiter = nil
+	assert.NoError(t, c.Connect("integration-test"))
+	return c
+}
+
+func TestConnect(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	c := connect(t, g)
+
+	psk, ok := g.PSK("integration-test")
+	assert.True(ok)
+	assert.Equal(psk, c.Credentials().PSK)
+
+	wrong := sladdfri.NewClientWithCredentials("localhost", sladdfri.Credentials{
+		Identity: "integration-test",
+		PSK:      "wrong",
+	})
+	wrong.Dial = g.Dial
+	assert.Error(wrong.Connect(""))
+}
+
+func TestDevicesAndGroups(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	first, err := g.AddDevice(newLight("Kitchen"))
+	assert.NoError(err)
+	second, err := g.AddDevice(newLight("Hallway"))
+	assert.NoError(err)
+	assert.Equal(uint32(65536), first)
+	c := connect(t, g)
+
+	devices, err := c.ListDevices()
+	assert.NoError(err)
+	assert.Len(devices, 2)
+	assert.Equal("Hallway", devices[1].Name)
+
+	assert.NoError(c.SetDevice(first, sladdfri.NewLightUpdate().On().Dim(100)))
+	var device sladdfri.Device
+	assert.NoError(g.Resource("/15001/65536", &device))
+	assert.Equal(uint8(1), device.LightControl[0].Power)
+	assert.Equal(uint8(100), device.LightControl[0].Dim)
+	assert.Equal(370, device.LightControl[0].Mireds)
+
+	assert.NoError(c.AddGroup([]uint32{first}, "Downstairs"))
+	groups, err := c.ListGroups()
+	assert.NoError(err)
+	assert.Len(groups, 1)
+	group, err := c.AddDevicesToGroup(groups[0].ID, []uint32{second})
+	assert.NoError(err)
+	assert.Equal([]uint32{first, second}, group.AccessoryLink.LinkedItems.DeviceIDs)
+	group, err = c.RemoveDevicesFromGroup(group.ID, []uint32{first})
+	assert.NoError(err)
+	assert.Equal([]uint32{second}, group.AccessoryLink.LinkedItems.DeviceIDs)
+
+	sub, err := c.SubscribeDevice(second)
+	assert.NoError(err)
+	assert.True(g.Observed("/15001/65537"))
+	assert.NoError(c.SetGroup(group.ID, sladdfri.NewLightUpdate().Off()))
+	select {
+	case update := <-sub.Updates():
+		assert.Equal(uint8(0), update.LightControl[0].Power)
+	case <-time.After(time.Second):
+		t.Fatal("No notification received")
+	}
+	assert.NoError(sub.Cancel())
+	assert.False(g.Observed("/15001/65537"))
+
+	requests := g.Requests()
+	last := requests[len(requests)-1]
+	assert.Equal(canopus.Put, last.Method)
+	assert.Equal("/15004/131073", last.URI)
+	assert.JSONEq(`{"5850":0}`, string(last.Payload))
+}
+
+func TestMoods(t *testing.T) {
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	id, err := g.AddDevice(newLight("Kitchen"))
+	assert.NoError(err)
+	c := connect(t, g)
+
+	assert.NoError(c.AddGroup([]uint32{id}, "Kitchen"))
+	assert.NoError(c.CreateMood("Dinner", []sladdfri.LightControl{{ID: id, Power: 1, Dim: 80, Mireds: 454}}))
+	moods, err := c.ListMoods()
+	assert.NoError(err)
+	assert.Len(moods, 1)
+	assert.Equal("Dinner", moods[0].Name)
+
+	assert.NoError(c.ActivateMood(131073, moods[0].ID))
+	device, err := c.GetDevice(id)
+	assert.NoError(err)
+	assert.Equal(uint8(80), device.LightControl[0].Dim)
+	assert.Equal(454, device.LightControl[0].Mireds)
+}
+
+func TestClockDrift(t *testing.T) {
+	assert := assert.New(t)
+	c := connect(t, New("gatewaycode"))
+
+	gateway, err := c.GetGateway()
+	assert.NoError(err)
+	now, err := gateway.CurrentTime()
+	assert.NoError(err)
+	assert.WithinDuration(time.Now(), now, time.Second)
+
+	drift, err := c.ClockDrift()
+	assert.NoError(err)
+	assert.True(drift > -time.Second && drift < time.Second)
+}
+
+func TestServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping DTLS server in short mode")
+	}
+	assert := assert.New(t)
+	g := New("gatewaycode")
+	_, err := g.AddDevice(newLight("Kitchen"))
+	assert.NoError(err)
+
+	server, err := Serve(g)
+	if !assert.NoError(err) {
+		return
+	}
+	defer server.Close()
+
+	c := sladdfri.NewClient(server.Addr, g.Key)
+	assert.NoError(c.Connect("integration-test"))
+	defer c.Close()
+	devices, err := c.ListDevices()
+	assert.NoError(err)
+	assert.Len(devices, 1)
+}
@@ -0,0 +1,145 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Hjdskes/sladdfri"
+	"github.com/zubairhamed/canopus"
+)
+
+var (
+	errUnknownIdentity = errors.New("Unknown identity")
+	errWrongKey        = errors.New("Wrong preshared key")
+	errClosed          = errors.New("Transport is closed")
+)
+
+// Connects to this gateway in the same process, without any networking. The
+// identity and preshared key are checked like the gateway checks them during
+// the DTLS handshake. Dial can be used as a sladdfri.DialFunc:
+//
+//	client := sladdfri.NewClient("localhost", gateway.Key)
+//	client.Dial = gateway.Dial
+func (g *Gateway) Dial(ctx context.Context, address, identity, psk string) (sladdfri.Transport, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+	key, ok := g.keyFor(identity)
+	if !ok {
+		return nil, errUnknownIdentity
+	}
+	if key != psk {
+		return nil, errWrongKey
+	}
+	return g.Transport(), nil
+}
+
+// Returns a new sladdfri.Transport talking to this gateway in the same
+// process, skipping authentication altogether.
+func (g *Gateway) Transport() sladdfri.Transport {
+	t := &transport{
+		gateway:       g,
+		observed:      make(map[string]bool),
+		notifications: make(chan sladdfri.Notification, 64),
+		closed:        make(chan struct{}),
+	}
+	t.listener = g.listen(t.notify)
+	return t
+}
+
+// An in-process sladdfri.Transport, see Gateway.Transport.
+type transport struct {
+	gateway  *Gateway
+	listener *listener
+
+	// Protects observed.
+	mu       sync.Mutex
+	observed map[string]bool
+
+	notifications chan sladdfri.Notification
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+func (t *transport) Send(ctx context.Context, req sladdfri.Request) (*sladdfri.Response, error) {
+	select {
+	case <-t.closed:
+		return nil, errClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	return t.gateway.Handle(req), nil
+}
+
+func (t *transport) Observe(ctx context.Context, uri string) error {
+	resp, err := t.Send(ctx, sladdfri.Request{Method: canopus.Get, URI: uri})
+	if err != nil {
+		return err
+	}
+	if resp.Code != canopus.CoapCodeContent {
+		return errors.New("Cannot observe " + uri)
+	}
+
+	uri = normalize(uri)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.observed[uri] {
+		t.observed[uri] = true
+		t.gateway.observe(uri)
+	}
+	return nil
+}
+
+func (t *transport) CancelObserve(ctx context.Context, uri string) error {
+	select {
+	case <-t.closed:
+		return errClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	uri = normalize(uri)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.observed[uri] {
+		delete(t.observed, uri)
+		t.gateway.cancelObserve(uri)
+	}
+	return nil
+}
+
+func (t *transport) Notifications() <-chan sladdfri.Notification {
+	return t.notifications
+}
+
+func (t *transport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		t.gateway.unlisten(t.listener)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for uri := range t.observed {
+			t.gateway.cancelObserve(uri)
+		}
+		t.observed = nil
+	})
+	return nil
+}
+
+func (t *transport) notify(uri string, payload []byte) {
+	t.mu.Lock()
+	observed := t.observed[uri]
+	t.mu.Unlock()
+	if !observed {
+		return
+	}
+	select {
+	case t.notifications <- sladdfri.Notification{URI: uri, Payload: payload}:
+	case <-t.closed:
+	}
+}
@@ -1,10 +1,13 @@
 package sladdfri
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/zubairhamed/canopus"
@@ -13,22 +16,83 @@ import (
 const (
 	tradfriPort     = 5684
 	preauthIdentity = "Client_identity"
+	defaultTimeout  = 10 * time.Second
 )
 
 // Client represent the connection to a Trådfri gateway. Any and all
 // communication goes through this struct's methods.
 type Client struct {
-	// Hostname or IP address of the gateway for this client
+	// Hostname or IP address of the gateway for this client, optionally
+	// followed by a port if the gateway does not listen on the default
+	// port 5684, e.g. a simulated gateway
 	Gateway string
 
 	// Gateway code at the bottom of your gateway; used for authentication
 	Key string
 
+	// Where negotiated credentials are persisted, if anywhere. Connect
+	// consults this store before negotiating a new preshared key.
+	CredentialStore CredentialStore
+
+	// Connects to the gateway, both in Connect and when a supervised
+	// connection is re-established. Defaults to DialDTLS.
+	Dial DialFunc
+
+	// Maximum duration of a single request to the gateway, applied when
+	// the context of the request carries no deadline of its own. Zero
+	// means no timeout.
+	Timeout time.Duration
+
+	// Limits the rate of all messages sent to the gateway. Defaults to one
+	// message every 100 milliseconds, with bursts of up to 10 messages.
+	// Nil means no limit.
+	RateLimiter *RateLimiter
+
+	// The maximum number of items fetched at once by ListDevices,
+	// ListGroups, ListMoods and ListSmartTasks. Defaults to 4; values below
+	// 1 fetch the items one at a time. Fetching concurrently overlaps the
+	// round trips to the gateway, but all requests are still subject to the
+	// RateLimiter: beyond its burst, listing is paced by the RateLimiter
+	// rather than by the gateway's response time.
+	Concurrency int
+
+	// Receives the log entries of this client. Nil, the default, means
+	// nothing is logged. See NewStdLogger.
+	Logger Logger
+
+	// Identity to use when communicating with the gateway
+	identity string
+
 	// Preshared key to use when communicating with the gateway
 	psk string
 
-	// CoAP connection with the gateway
-	connection canopus.Connection
+	// Protects identity, psk, transport, subscriptions, observing and
+	// forwarding.
+	mu sync.RWMutex
+
+	// Connection with the gateway
+	transport Transport
+
+	// Active subscriptions by URI. Their observations are registered
+	// again after reconnecting.
+	subscriptions map[string][]*Subscription
+
+	// Serialise registering and deregistering observations by URI, see
+	// lockObservation.
+	observing map[string]*observationLock
+
+	// Closed to stop forwarding the notifications of the current
+	// transport to the subscriptions. Nil until the first subscription.
+	forwarding chan struct{}
+
+	// The types and capabilities of the devices seen by GetDevice, so that
+	// updates need not fetch a device before every update.
+	devices map[uint32]deviceInfo
+
+	// Signalled when a request fails because of the transport, see
+	// Supervise.
+	failures     chan struct{}
+	failuresOnce sync.Once
 }
 
 // A PSKRequest is sent to the gateway in an authentication request.
@@ -46,74 +110,246 @@ type PSKResponse struct {
 // Creates a new Client, connecting to the given gateway using the given authentication.
 func NewClient(gateway, key string) *Client {
 	return &Client{
-		Gateway: gateway,
-		Key:     key,
+		Gateway:     gateway,
+		Key:         key,
+		Timeout:     defaultTimeout,
+		RateLimiter: NewRateLimiter(defaultRateInterval, defaultRateBurst),
+		Concurrency: defaultConcurrency,
+	}
+}
+
+// Creates a new Client, connecting to the given gateway using previously
+// negotiated credentials. See Client.Credentials.
+func NewClientWithCredentials(gateway string, creds Credentials) *Client {
+	return &Client{
+		Gateway:     gateway,
+		Timeout:     defaultTimeout,
+		RateLimiter: NewRateLimiter(defaultRateInterval, defaultRateBurst),
+		Concurrency: defaultConcurrency,
+		identity:    creds.Identity,
+		psk:         creds.PSK,
+	}
+}
+
+// Creates a new Client that communicates with the gateway over the given
+// Transport. Such a Client needs no call to Connect.
+func NewClientWithTransport(transport Transport) *Client {
+	return &Client{
+		Timeout:     defaultTimeout,
+		RateLimiter: NewRateLimiter(defaultRateInterval, defaultRateBurst),
+		Concurrency: defaultConcurrency,
+		transport:   transport,
 	}
 }
 
-// Connects the client to its gateway using the given identifier.
+// Connects the client to its gateway using the given identifier. If the
+// identifier is empty, the identity the client was created with or the one
+// found in its CredentialStore is used instead. A new preshared key is only
+// negotiated if none is known for the identity.
 func (c *Client) Connect(ident string) error {
-	address := fmt.Sprintf("%s:%d", c.Gateway, tradfriPort)
-	log.Printf("Connecting to gateway: %s\n", address)
+	return c.ConnectContext(context.Background(), ident)
+}
+
+// Returns the address of the gateway, including the port.
+func (c *Client) address() string {
+	if _, _, err := net.SplitHostPort(c.Gateway); err == nil {
+		return c.Gateway
+	}
+	return net.JoinHostPort(c.Gateway, strconv.Itoa(tradfriPort))
+}
 
-	if c.psk == "" {
-		err := c.generatePSK(address, ident)
+// Like Connect, but gives up when the given context is done.
+func (c *Client) ConnectContext(ctx context.Context, ident string) error {
+	address := c.address()
+	c.log(LevelInfo, "Connecting to gateway", Field{"address", address})
+
+	creds := c.Credentials()
+	if ident != "" && ident != creds.Identity {
+		creds = Credentials{Identity: ident}
+	}
+
+	if creds.PSK == "" && c.CredentialStore != nil {
+		stored, err := c.CredentialStore.Load(c.Gateway)
 		if err != nil {
 			return err
 		}
+		if stored != nil && (creds.Identity == "" || creds.Identity == stored.Identity) {
+			creds = *stored
+		}
 	}
+	c.setCredentials(creds)
 
-	var err error
-	c.connection, err = canopus.DialDTLS(address, ident, c.psk)
-	return err
-}
+	if creds.Identity == "" {
+		return errors.New("No identity to connect with")
+	}
 
-func (c *Client) generatePSK(address, ident string) error {
-	log.Printf("Requesting PSK...\n")
+	if creds.PSK == "" {
+		psk, err := c.generatePSK(ctx, address, creds.Identity)
+		if err != nil {
+			return err
+		}
+		creds.PSK = psk
+		c.setCredentials(creds)
+		if c.CredentialStore != nil {
+			err = c.CredentialStore.Save(c.Gateway, creds)
+			if err != nil {
+				return err
+			}
+		}
+	}
 
-	conn, err := canopus.DialDTLS(address, preauthIdentity, c.Key)
+	transport, err := c.dial(ctx, address, creds.Identity, creds.PSK)
 	if err != nil {
 		return err
 	}
+	c.setTransport(transport)
+	c.log(LevelInfo, "Connected to gateway", Field{"address", address}, Field{"identity", creds.Identity})
+	return nil
+}
+
+// Returns the identity and preshared key this client uses, so that they can
+// be passed to NewClientWithCredentials later on. The preshared key is empty
+// until it has been negotiated by Connect.
+func (c *Client) Credentials() Credentials {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Credentials{
+		Identity: c.identity,
+		PSK:      c.psk,
+	}
+}
+
+func (c *Client) setCredentials(creds Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity = creds.Identity
+	c.psk = creds.PSK
+}
+
+// Closes the connection with the gateway. Closing a client that was never
+// connected does nothing.
+func (c *Client) Close() error {
+	transport := c.currentTransport()
+	if transport == nil {
+		return nil
+	}
+	return transport.Close()
+}
+
+func (c *Client) dial(ctx context.Context, address, identity, psk string) (Transport, error) {
+	if c.Dial != nil {
+		return c.Dial(ctx, address, identity, psk)
+	}
+	return DialDTLS(ctx, address, identity, psk)
+}
+
+func (c *Client) currentTransport() Transport {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.transport
+}
+
+// Replaces the transport of this client, closing the previous one.
+func (c *Client) setTransport(transport Transport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.transport != nil {
+		c.transport.Close()
+	}
+	c.transport = transport
+
+	if c.forwarding != nil {
+		close(c.forwarding)
+		c.forwarding = make(chan struct{})
+		go c.forward(transport.Notifications(), c.forwarding)
+	}
+}
+
+// Negotiates a preshared key for the given identity using the gateway code.
+func (c *Client) generatePSK(ctx context.Context, address, ident string) (string, error) {
+	c.log(LevelInfo, "Requesting preshared key", Field{"identity", ident})
 
-	payload := PSKRequest{Ident: ident}
-	// TODO: cannot use c.postRequest because we need to process the status code of the reply.
-	req := canopus.NewRequest(canopus.MessageConfirmable, canopus.Post)
-	req.SetRequestURI(uriGatewayIdent)
-	data := canopus.NewJSONPayload(payload).GetBytes()
-	req.SetPayload(data)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
-	resp, err := conn.Send(req)
+	transport, err := c.dial(ctx, address, preauthIdentity, c.Key)
 	if err != nil {
-		return err
+		return "", err
+	}
+	defer transport.Close()
+
+	data, err := json.Marshal(PSKRequest{Ident: ident})
+	if err != nil {
+		return "", err
+	}
+	// Cannot use c.postRequest because we need to process the status code of the reply.
+	req := Request{
+		Method:  canopus.Post,
+		URI:     uriGatewayIdent,
+		Payload: data,
+	}
+	err = c.wait(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := transport.Send(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	err = checkResponse(req, resp)
+	if err != nil {
+		return "", err
 	}
 
-	if resp.GetMessage().GetCode() == canopus.CoapCodeCreated {
+	if resp.Code == canopus.CoapCodeCreated {
 		var pskResp PSKResponse
-		err := json.Unmarshal(resp.GetMessage().GetPayload().GetBytes(), &pskResp)
-		if err == nil {
-			c.psk = pskResp.PSK
-			log.Printf("PSK: %s\n", c.psk)
+		err := json.Unmarshal(resp.Payload, &pskResp)
+		if err != nil {
+			return "", err
 		}
-		return nil
+		c.log(LevelInfo, "Negotiated preshared key", Field{"identity", ident})
+		return pskResp.PSK, nil
 	} else {
-		return errors.New("Unable to get PSK")
+		return "", errors.New("Unable to get PSK")
 	}
 }
 
-func (c *Client) observe(uri string) error {
-	_, err := c.connection.ObserveResource(uri)
-	return err
+// Derives a context bounded by c.Timeout, unless ctx already carries a
+// deadline.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.Timeout)
 }
 
-func (c *Client) request(uri string, messageMethod canopus.CoapCode, payload interface{}) ([]byte, error) {
-	req := canopus.NewRequest(canopus.MessageConfirmable, messageMethod)
+// Waits for the given duration, or until the given context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) request(ctx context.Context, uri string, messageMethod canopus.CoapCode, payload interface{}) ([]byte, error) {
+	req := Request{
+		Method: messageMethod,
+		URI:    uri,
+	}
 
 	switch messageMethod {
 	case canopus.Put, canopus.Post:
 		if payload != nil {
-			data := canopus.NewJSONPayload(payload).GetBytes()
-			req.SetPayload(data)
+			data, err := json.Marshal(payload)
+			if err != nil {
+				return nil, err
+			}
+			req.Payload = data
 		}
 	case canopus.Get, canopus.Delete:
 		// Do nothing.
@@ -122,75 +358,125 @@ func (c *Client) request(uri string, messageMethod canopus.CoapCode, payload int
 		return nil, errors.New(error)
 	}
 
-	req.SetRequestURI(uri)
-	resp, err := c.connection.Send(req)
+	transport := c.currentTransport()
+	if transport == nil {
+		return nil, errNotConnected
+	}
+
+	reqCtx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	err := c.wait(reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := transport.Send(reqCtx, req)
+	fields := []Field{
+		{"method", methodString(req.Method)},
+		{"uri", req.URI},
+		{"latency", time.Since(start)},
+	}
+	if err != nil {
+		c.checkFailure(ctx, err)
+	} else {
+		fields = append(fields, Field{"code", codeString(resp.Code)})
+		err = checkResponse(req, resp)
+	}
 	if err != nil {
-		log.Printf("<- error: %+v", err)
+		c.log(LevelWarn, "Request failed", append(fields, Field{"error", err})...)
 		return nil, err
 	}
-	rdata := resp.GetMessage().GetPayload().GetBytes()
-	log.Printf("<- %s", string(rdata))
-	return rdata, nil
+	c.log(LevelDebug, "Request", append(fields, Field{"request", req.Payload}, Field{"response", resp.Payload})...)
+	return resp.Payload, nil
 }
 
-func (c *Client) putRequest(uri string, payload interface{}) error {
-	log.Printf("PUT %s payload %s", uri, payload)
-	_, err := c.request(uri, canopus.Put, payload)
+func (c *Client) putRequest(ctx context.Context, uri string, payload interface{}) error {
+	_, err := c.request(ctx, uri, canopus.Put, payload)
 	return err
 }
 
-func (c *Client) postRequest(uri string, payload interface{}) error {
-	log.Printf("POST %s", uri)
-	_, err := c.request(uri, canopus.Post, payload)
+func (c *Client) postRequest(ctx context.Context, uri string, payload interface{}) error {
+	_, err := c.request(ctx, uri, canopus.Post, payload)
 	return err
 }
 
-func (c *Client) getRequest(uri string, out interface{}) error {
-	log.Printf("GET %s", uri)
-	data, err := c.request(uri, canopus.Get, nil)
+func (c *Client) getRequest(ctx context.Context, uri string, out interface{}) error {
+	data, err := c.request(ctx, uri, canopus.Get, nil)
 	if err == nil {
 		err = json.Unmarshal(data, out)
 	}
 	return err
 }
 
-func (c *Client) deleteRequest(uri string) error {
-	log.Printf("DELETE %s", uri)
-	_, err := c.request(uri, canopus.Delete, nil)
+func (c *Client) deleteRequest(ctx context.Context, uri string) error {
+	_, err := c.request(ctx, uri, canopus.Delete, nil)
 	return err
 }
 
 // Sets the NTP server used by the gateway.
 func (c *Client) SetNTP(NTPServer string) error {
-	payload := Gateway{
+	return c.SetNTPContext(context.Background(), NTPServer)
+}
+
+// Like SetNTP, but gives up when the given context is done.
+func (c *Client) SetNTPContext(ctx context.Context, NTPServer string) error {
+	var v validator
+	v.notEmpty("NTPServer", NTPServer)
+	err := v.err()
+	if err != nil {
+		return err
+	}
+
+	payload := NTPRequest{
 		NTPServer: NTPServer,
 	}
-	return c.putRequest(uriGatewayInfo, payload)
+	return c.putRequest(ctx, uriGatewayInfo, payload)
 }
 
 // Sets the gateway into commissioning mode for the given duration in
 // seconds.
 func (c *Client) SetCommissioningMode(seconds uint32) error {
-	payload := Gateway{
+	return c.SetCommissioningModeContext(context.Background(), seconds)
+}
+
+// Like SetCommissioningMode, but gives up when the given context is done.
+func (c *Client) SetCommissioningModeContext(ctx context.Context, seconds uint32) error {
+	payload := CommissioningModeRequest{
 		CommissioningMode: seconds,
 	}
-	return c.putRequest(uriGatewayInfo, payload)
+	return c.putRequest(ctx, uriGatewayInfo, payload)
 }
 
 // Reboots the gateway.
 func (c *Client) Reboot() error {
-	return c.postRequest(uriGatewayReboot, nil)
+	return c.RebootContext(context.Background())
+}
+
+// Like Reboot, but gives up when the given context is done.
+func (c *Client) RebootContext(ctx context.Context) error {
+	return c.postRequest(ctx, uriGatewayReboot, nil)
 }
 
 // Resets the gateway to factory defaults.
 func (c *Client) FactoryReset() error {
-	return c.postRequest(uriGatewayFactoryReset, nil)
+	return c.FactoryResetContext(context.Background())
+}
+
+// Like FactoryReset, but gives up when the given context is done.
+func (c *Client) FactoryResetContext(ctx context.Context) error {
+	return c.postRequest(ctx, uriGatewayFactoryReset, nil)
 }
 
 // Gets the gateway information, see Gateway.
 func (c *Client) GetGateway() (*Gateway, error) {
+	return c.GetGatewayContext(context.Background())
+}
+
+// Like GetGateway, but gives up when the given context is done.
+func (c *Client) GetGatewayContext(ctx context.Context) (*Gateway, error) {
 	var gatewayInfo Gateway
-	err := c.getRequest(uriGatewayInfo, &gatewayInfo)
+	err := c.getRequest(ctx, uriGatewayInfo, &gatewayInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -199,9 +485,14 @@ func (c *Client) GetGateway() (*Gateway, error) {
 
 // Gets the given group's information, see Group.
 func (c *Client) GetGroup(id uint32) (*Group, error) {
+	return c.GetGroupContext(context.Background(), id)
+}
+
+// Like GetGroup, but gives up when the given context is done.
+func (c *Client) GetGroupContext(ctx context.Context, id uint32) (*Group, error) {
 	uri := fmt.Sprintf("%s/%d", uriGroups, id)
 	var desc Group
-	err := c.getRequest(uri, &desc)
+	err := c.getRequest(ctx, uri, &desc)
 	if err != nil {
 		return nil, err
 	}
@@ -210,16 +501,21 @@ func (c *Client) GetGroup(id uint32) (*Group, error) {
 
 // Gets the given mood's information, see Mood.
 func (c *Client) GetMood(id uint32, parent *uint32) (*Mood, error) {
+	return c.GetMoodContext(context.Background(), id, parent)
+}
+
+// Like GetMood, but gives up when the given context is done.
+func (c *Client) GetMoodContext(ctx context.Context, id uint32, parent *uint32) (*Mood, error) {
 	if parent == nil {
 		var err error
-		parent, err = c.moodParent()
+		parent, err = c.moodParent(ctx)
 		if err != nil {
 			return nil, err
 		}
 	}
 	uri := fmt.Sprintf("%s/%d/%d", uriMoods, *parent, id)
 	var desc Mood
-	err := c.getRequest(uri, &desc)
+	err := c.getRequest(ctx, uri, &desc)
 	if err != nil {
 		return nil, err
 	}
@@ -228,27 +524,159 @@ func (c *Client) GetMood(id uint32, parent *uint32) (*Mood, error) {
 
 // Gets the given device's information, see Device.
 func (c *Client) GetDevice(id uint32) (*Device, error) {
+	return c.GetDeviceContext(context.Background(), id)
+}
+
+// Like GetDevice, but gives up when the given context is done.
+func (c *Client) GetDeviceContext(ctx context.Context, id uint32) (*Device, error) {
 	uri := fmt.Sprintf("%s/%d", uriDevices, id)
 	var desc Device
-	err := c.getRequest(uri, &desc)
+	err := c.getRequest(ctx, uri, &desc)
 	if err != nil {
 		return nil, err
 	}
+	c.mu.Lock()
+	if c.devices == nil {
+		c.devices = make(map[uint32]deviceInfo)
+	}
+	c.devices[id] = deviceInfo{desc.Type, desc.Capabilities()}
+	c.mu.Unlock()
 	return &desc, nil
 }
 
+// What a Client remembers of a device it has seen.
+type deviceInfo struct {
+	typ  DeviceType
+	caps Capabilities
+}
+
+// Returns the type and capabilities of the given device, fetching the device
+// unless it has been seen before.
+func (c *Client) deviceInfo(ctx context.Context, id uint32) (deviceInfo, error) {
+	c.mu.RLock()
+	info, ok := c.devices[id]
+	c.mu.RUnlock()
+	if ok {
+		return info, nil
+	}
+	device, err := c.GetDeviceContext(ctx, id)
+	if err != nil {
+		return deviceInfo{}, err
+	}
+	return deviceInfo{device.Type, device.Capabilities()}, nil
+}
+
+// Returns the capabilities of the given device, fetching the device unless it
+// has been seen before.
+func (c *Client) deviceCapabilities(ctx context.Context, id uint32) (Capabilities, error) {
+	info, err := c.deviceInfo(ctx, id)
+	return info.caps, err
+}
+
 // Adds a new group to the gateway, consisting of the given devices
 // using the given name.
 func (c *Client) AddGroup(ids []uint32, name string) error {
-	log.Printf("ID: %v\n", ids)
+	return c.AddGroupContext(context.Background(), ids, name)
+}
+
+// Like AddGroup, but gives up when the given context is done.
+func (c *Client) AddGroupContext(ctx context.Context, ids []uint32, name string) error {
+	var v validator
+	v.notEmpty("Name", name)
+	err := v.err()
+	if err != nil {
+		return err
+	}
 
-	existingIds, err := c.ListDeviceIds()
+	err = c.checkDevicesExist(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	payload := AddGroupRequest{
+		ID:   ids,
+		Name: name,
+	}
+	return c.putRequest(ctx, uriGroupAdd, payload)
+}
+
+// Adds the given devices to the given existing group, returning the updated
+// group.
+func (c *Client) AddDevicesToGroup(groupID uint32, ids []uint32) (*Group, error) {
+	return c.AddDevicesToGroupContext(context.Background(), groupID, ids)
+}
+
+// Like AddDevicesToGroup, but gives up when the given context is done.
+func (c *Client) AddDevicesToGroupContext(ctx context.Context, groupID uint32, ids []uint32) (*Group, error) {
+	return c.changeGroupMembers(ctx, uriGroupAdd, groupID, ids)
+}
+
+// Removes the given devices from the given group, returning the updated
+// group.
+func (c *Client) RemoveDevicesFromGroup(groupID uint32, ids []uint32) (*Group, error) {
+	return c.RemoveDevicesFromGroupContext(context.Background(), groupID, ids)
+}
+
+// Like RemoveDevicesFromGroup, but gives up when the given context is done.
+func (c *Client) RemoveDevicesFromGroupContext(ctx context.Context, groupID uint32, ids []uint32) (*Group, error) {
+	return c.changeGroupMembers(ctx, uriGroupRemove, groupID, ids)
+}
+
+func (c *Client) changeGroupMembers(ctx context.Context, uri string, groupID uint32, ids []uint32) (*Group, error) {
+	var v validator
+	if len(ids) == 0 {
+		v.invalid("ID", "[]", "must not be empty")
+	}
+	err := v.err()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.checkDevicesExist(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := GroupMembersRequest{GroupID: groupID}
+	payload.AccessoryLink.LinkedItems.DeviceIDs = ids
+	err = c.putRequest(ctx, uri, payload)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetGroupContext(ctx, groupID)
+}
+
+// Renames the given group, returning the updated group.
+func (c *Client) RenameGroup(id uint32, name string) (*Group, error) {
+	return c.RenameGroupContext(context.Background(), id, name)
+}
+
+// Like RenameGroup, but gives up when the given context is done.
+func (c *Client) RenameGroupContext(ctx context.Context, id uint32, name string) (*Group, error) {
+	var v validator
+	v.notEmpty("Name", name)
+	err := v.err()
+	if err != nil {
+		return nil, err
+	}
+
+	uri := fmt.Sprintf("%s/%d", uriGroups, id)
+	err = c.putRequest(ctx, uri, RenameGroupRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return c.GetGroupContext(ctx, id)
+}
+
+// The gateway happily accepts groups consisting of non-existing device
+// identifiers. This function scans for non-existing identifiers to prevent
+// this.
+func (c *Client) checkDevicesExist(ctx context.Context, ids []uint32) error {
+	existingIds, err := c.ListDeviceIdsContext(ctx)
 	if err != nil {
 		return err
 	}
 
-	// The gateway happily accepts groups consisting of non-existing device identifiers.
-	// This loop scans for non-existing identifiers to prevent this.
 	for _, id := range ids {
 		var found bool
 		for _, existingId := range existingIds {
@@ -261,214 +689,374 @@ func (c *Client) AddGroup(ids []uint32, name string) error {
 			return errors.New("All identifiers must exist")
 		}
 	}
+	return nil
+}
 
-	payload := AddGroupRequest{
-		ID:   ids,
-		Name: name,
-	}
-	return c.putRequest(uriGroupAdd, payload)
+// Changes the settings of all bulbs in the given group at once, as described
+// by the given LightUpdate. Setting the color or color temperature of a whole
+// group through this method makes all bulbs change simultaneously, rather
+// than one after the other. Only the counter of the time the bulbs have been
+// on cannot be reset for a group.
+func (c *Client) SetGroup(id uint32, update *LightUpdate) error {
+	return c.SetGroupContext(context.Background(), id, update)
 }
 
-// Changes the group's, whose identifier matches the one from the
-// given Group, settings to that of the given Group.
-func (c *Client) SetGroup(g Group) error {
-	uri := fmt.Sprintf("%s/%d", uriGroups, g.ID)
-	return c.putRequest(uri, g)
+// Like SetGroup, but gives up when the given context is done.
+func (c *Client) SetGroupContext(ctx context.Context, id uint32, update *LightUpdate) error {
+	var v validator
+	update.validate(&v, id, nil)
+	if update != nil && update.onTime != nil {
+		v.invalid("OnTime", *update.onTime, "cannot be reset for a group")
+	}
+	err := v.err()
+	if err != nil {
+		return err
+	}
+
+	uri := fmt.Sprintf("%s/%d", uriGroups, id)
+	return c.putRequest(ctx, uri, update)
 }
 
 // Removes the given group from the gateway.
 func (c *Client) RemoveGroup(id uint32) error {
-	// TODO: why does this not have to use /15004/remove?
-	return c.deleteRequest(fmt.Sprintf("%s/%d", uriGroups, id))
+	return c.RemoveGroupContext(context.Background(), id)
+}
+
+// Like RemoveGroup, but gives up when the given context is done.
+func (c *Client) RemoveGroupContext(ctx context.Context, id uint32) error {
+	return c.deleteRequest(ctx, fmt.Sprintf("%s/%d", uriGroups, id))
 }
 
 // Adds a mood of the given name to the gateway.
 func (c *Client) AddMood(name string) error {
-	parent, err := c.moodParent()
+	return c.AddMoodContext(context.Background(), name)
+}
+
+// Like AddMood, but gives up when the given context is done.
+func (c *Client) AddMoodContext(ctx context.Context, name string) error {
+	return c.CreateMoodContext(ctx, name, nil)
+}
+
+// Adds a mood of the given name to the gateway, setting the lights in it as
+// described by the given LightControls. Only the identifier, power, dim and
+// color settings of each LightControl are used. Returns a *ValidationError if
+// any setting is out of range or not supported by its light.
+func (c *Client) CreateMood(name string, lights []LightControl) error {
+	return c.CreateMoodContext(context.Background(), name, lights)
+}
+
+// Like CreateMood, but gives up when the given context is done.
+func (c *Client) CreateMoodContext(ctx context.Context, name string, lights []LightControl) error {
+	var v validator
+	v.notEmpty("Name", name)
+	err := c.validateMoodLights(ctx, &v, lights)
+	if err != nil {
+		return err
+	}
+	err = v.err()
+	if err != nil {
+		return err
+	}
+
+	parent, err := c.moodParent(ctx)
 	if err != nil {
 		return err
 	}
 
 	uri := fmt.Sprintf("%s/%d", uriMoods, *parent)
 	payload := AddMoodRequest{
-		Name:     name,
-		IsActive: 1,
+		Name:          name,
+		IsActive:      1,
+		LightControls: moodLightControls(lights),
 	}
-	return c.postRequest(uri, payload)
+	return c.postRequest(ctx, uri, payload)
+}
+
+// Replaces the settings of the lights in the given mood by those described
+// by the given LightControls, see CreateMood.
+func (c *Client) UpdateMood(id uint32, lights []LightControl) error {
+	return c.UpdateMoodContext(context.Background(), id, lights)
+}
+
+// Like UpdateMood, but gives up when the given context is done.
+func (c *Client) UpdateMoodContext(ctx context.Context, id uint32, lights []LightControl) error {
+	var v validator
+	if len(lights) == 0 {
+		v.invalid("LightControls", "[]", "must not be empty")
+	}
+	err := c.validateMoodLights(ctx, &v, lights)
+	if err != nil {
+		return err
+	}
+	err = v.err()
+	if err != nil {
+		return err
+	}
+
+	return c.updateMood(ctx, id, UpdateMoodRequest{
+		LightControls: moodLightControls(lights),
+	})
+}
+
+// Renames the given mood.
+func (c *Client) RenameMood(id uint32, name string) error {
+	return c.RenameMoodContext(context.Background(), id, name)
+}
+
+// Like RenameMood, but gives up when the given context is done.
+func (c *Client) RenameMoodContext(ctx context.Context, id uint32, name string) error {
+	var v validator
+	v.notEmpty("Name", name)
+	err := v.err()
+	if err != nil {
+		return err
+	}
+
+	return c.updateMood(ctx, id, UpdateMoodRequest{
+		Name: name,
+	})
+}
+
+func (c *Client) updateMood(ctx context.Context, id uint32, payload UpdateMoodRequest) error {
+	parent, err := c.moodParent(ctx)
+	if err != nil {
+		return err
+	}
+	uri := fmt.Sprintf("%s/%d/%d", uriMoods, *parent, id)
+	return c.putRequest(ctx, uri, payload)
+}
+
+// Activates the given mood on the given group, switching the group on. The
+// mood is looked up first, so that activating a mood that does not exist fails
+// with ErrNotFound rather than being sent to the group.
+func (c *Client) ActivateMood(groupID, moodID uint32) error {
+	return c.ActivateMoodContext(context.Background(), groupID, moodID)
+}
+
+// Like ActivateMood, but gives up when the given context is done.
+func (c *Client) ActivateMoodContext(ctx context.Context, groupID, moodID uint32) error {
+	_, err := c.GetMoodContext(ctx, moodID, nil)
+	if err != nil {
+		return err
+	}
+
+	uri := fmt.Sprintf("%s/%d", uriGroups, groupID)
+	payload := ActivateMoodRequest{
+		MoodID: moodID,
+		Power:  1,
+	}
+	return c.putRequest(ctx, uri, payload)
 }
 
 // Removes the given mood from the gateway.
 func (c *Client) RemoveMood(id uint32) error {
-	parent, err := c.moodParent()
+	return c.RemoveMoodContext(context.Background(), id)
+}
+
+// Like RemoveMood, but gives up when the given context is done.
+func (c *Client) RemoveMoodContext(ctx context.Context, id uint32) error {
+	parent, err := c.moodParent(ctx)
 	if err != nil {
 		return err
 	}
 	uri := fmt.Sprintf("%s/%d/%d", uriMoods, *parent, id)
-	return c.deleteRequest(uri)
+	return c.deleteRequest(ctx, uri)
 }
 
-// Changes the given device's settings to that of the given
-// LightControl.
-func (c *Client) SetDevice(id uint32, change LightControl) error {
+// Changes the given device's settings as described by the given
+// LightUpdate. Returns a *ValidationError if any value is out of range or
+// requires capabilities the device lacks, see Device.Capabilities.
+func (c *Client) SetDevice(id uint32, update *LightUpdate) error {
+	return c.SetDeviceContext(context.Background(), id, update)
+}
+
+// Like SetDevice, but gives up when the given context is done.
+func (c *Client) SetDeviceContext(ctx context.Context, id uint32, update *LightUpdate) error {
+	caps, err := c.deviceCapabilities(ctx, id)
+	if err != nil {
+		return err
+	}
+	var v validator
+	update.validate(&v, id, &caps)
+	err = v.err()
+	if err != nil {
+		return err
+	}
+
 	payload := DeviceSet{
-		[]LightControl{change},
+		[]*LightUpdate{update},
+	}
+	uri := fmt.Sprintf("%s/%d", uriDevices, id)
+	return c.putRequest(ctx, uri, payload)
+}
+
+// Switches the given control outlet on or off.
+func (c *Client) SetOutlet(id uint32, on bool) error {
+	return c.SetOutletContext(context.Background(), id, on)
+}
+
+// Like SetOutlet, but gives up when the given context is done.
+func (c *Client) SetOutletContext(ctx context.Context, id uint32, on bool) error {
+	err := c.checkDeviceType(ctx, id, Outlet)
+	if err != nil {
+		return err
+	}
+
+	var update PlugUpdate
+	if on {
+		update.Power = 1
+	}
+	payload := PlugSet{
+		[]PlugUpdate{update},
+	}
+	uri := fmt.Sprintf("%s/%d", uriDevices, id)
+	return c.putRequest(ctx, uri, payload)
+}
+
+// Moves the given blind to the given position, as a percentage: 0 is fully
+// open and 100 is fully closed.
+func (c *Client) SetBlindPosition(id uint32, percent float64) error {
+	return c.SetBlindPositionContext(context.Background(), id, percent)
+}
+
+// Like SetBlindPosition, but gives up when the given context is done.
+func (c *Client) SetBlindPositionContext(ctx context.Context, id uint32, percent float64) error {
+	var v validator
+	if percent < 0 || percent > 100 {
+		v.invalid("Position", percent, "is not in the range [0,100]")
+	}
+	err := v.err()
+	if err != nil {
+		return err
+	}
+
+	return c.setBlind(ctx, id, BlindUpdate{Position: &percent})
+}
+
+// Stops the given blind if it is moving.
+func (c *Client) StopBlind(id uint32) error {
+	return c.StopBlindContext(context.Background(), id)
+}
+
+// Like StopBlind, but gives up when the given context is done.
+func (c *Client) StopBlindContext(ctx context.Context, id uint32) error {
+	trigger := 0
+	return c.setBlind(ctx, id, BlindUpdate{Trigger: &trigger})
+}
+
+func (c *Client) setBlind(ctx context.Context, id uint32, update BlindUpdate) error {
+	err := c.checkDeviceType(ctx, id, Blind)
+	if err != nil {
+		return err
+	}
+
+	payload := BlindSet{
+		[]BlindUpdate{update},
 	}
 	uri := fmt.Sprintf("%s/%d", uriDevices, id)
-	return c.putRequest(uri, payload)
+	return c.putRequest(ctx, uri, payload)
 }
 
 // Removes the given device from the gateway.
 func (c *Client) RemoveDevice(id uint32) error {
-	return c.deleteRequest(fmt.Sprintf("%s/%d", uriDevices, id))
+	return c.RemoveDeviceContext(context.Background(), id)
+}
+
+// Like RemoveDevice, but gives up when the given context is done.
+func (c *Client) RemoveDeviceContext(ctx context.Context, id uint32) error {
+	return c.deleteRequest(ctx, fmt.Sprintf("%s/%d", uriDevices, id))
 }
 
 // Lists the identifiers of all devices connected to the gateway.
 func (c *Client) ListDeviceIds() (deviceIds []uint32, err error) {
-	err = c.getRequest(uriDevices, &deviceIds)
+	return c.ListDeviceIdsContext(context.Background())
+}
+
+// Like ListDeviceIds, but gives up when the given context is done.
+func (c *Client) ListDeviceIdsContext(ctx context.Context) (deviceIds []uint32, err error) {
+	err = c.getRequest(ctx, uriDevices, &deviceIds)
 	return deviceIds, err
 }
 
-// Lists the group settings of all devices connected to the gateway.
+// Lists the group settings of all devices connected to the gateway, ordered
+// by ID. If some groups cannot be fetched, the others are returned along with
+// a *ListError.
 func (c *Client) ListGroups() ([]*Group, error) {
-	log.Println("Requesting groups... ")
+	return c.ListGroupsContext(context.Background())
+}
+
+// Like ListGroups, but gives up when the given context is done.
+func (c *Client) ListGroupsContext(ctx context.Context) ([]*Group, error) {
 	var groupIds []uint32
-	err := c.getRequest(uriGroups, &groupIds)
+	err := c.getRequest(ctx, uriGroups, &groupIds)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("Enumerating...")
-	groups := make([]*Group, len(groupIds))
-	for i, group := range groupIds {
-		var desc *Group
-		desc, err = c.GetGroup(group)
-		if err != nil {
-			return nil, err
-		}
-		log.Printf("Found group: %+v\n", desc)
-		groups[i] = desc
-
-		// sleep for a while to avoid flood protection
-		time.Sleep(100 * time.Millisecond)
+	c.log(LevelDebug, "Enumerating groups", Field{"count", len(groupIds)})
+	items, err := c.enumerate(ctx, groupIds, func(ctx context.Context, id uint32) (interface{}, error) {
+		return c.GetGroupContext(ctx, id)
+	})
+	groups := make([]*Group, len(items))
+	for i, item := range items {
+		groups[i] = item.(*Group)
 	}
-
-	return groups, nil
+	return groups, err
 }
 
-// Lists the mood settings of all the moods on the gateway.
+// Lists the mood settings of all the moods on the gateway, ordered by ID. If
+// some moods cannot be fetched, the others are returned along with a
+// *ListError.
 func (c *Client) ListMoods() ([]*Mood, error) {
-	log.Println("Requesting moods... ")
-	parent, err := c.moodParent()
+	return c.ListMoodsContext(context.Background())
+}
+
+// Like ListMoods, but gives up when the given context is done.
+func (c *Client) ListMoodsContext(ctx context.Context) ([]*Mood, error) {
+	parent, err := c.moodParent(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	uri := fmt.Sprintf("%s/%d", uriMoods, *parent)
 	var moodIds []uint32
-	err = c.getRequest(uri, &moodIds)
+	err = c.getRequest(ctx, uri, &moodIds)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Println("Enumerating...")
-	moods := make([]*Mood, len(moodIds))
-	for i, mood := range moodIds {
-		var desc *Mood
-		desc, err = c.GetMood(mood, parent)
-		if err != nil {
-			return nil, err
-		}
-		log.Printf("Found mood: %+v\n", desc)
-		moods[i] = desc
-
-		// sleep for a while to avoid flood protection
-		time.Sleep(100 * time.Millisecond)
+	c.log(LevelDebug, "Enumerating moods", Field{"count", len(moodIds)})
+	items, err := c.enumerate(ctx, moodIds, func(ctx context.Context, id uint32) (interface{}, error) {
+		return c.GetMoodContext(ctx, id, parent)
+	})
+	moods := make([]*Mood, len(items))
+	for i, item := range items {
+		moods[i] = item.(*Mood)
 	}
-
-	return moods, nil
+	return moods, err
 }
 
 // Lists the device settings of all the devices connected to the
-// gateway.
+// gateway, ordered by ID. If some devices cannot be fetched, the others are
+// returned along with a *ListError.
 func (c *Client) ListDevices() (devices []*Device, err error) {
-	deviceIds, err := c.ListDeviceIds()
-	if err != nil {
-		return
-	}
-
-	log.Println("Enumerating...")
-	for _, device := range deviceIds {
-		var desc *Device
-		desc, err = c.GetDevice(device)
-		if err != nil {
-			return
-		}
-		log.Printf("Found device: %s\n", desc)
-		devices = append(devices, desc)
-
-		// sleep for a while to avoid flood protection
-		time.Sleep(100 * time.Millisecond)
-	}
-
-	return
+	return c.ListDevicesContext(context.Background())
 }
 
-func (c *Client) observerGateway(in chan canopus.ObserveMessage, out chan *Gateway) {
-	for msg := range in {
-		value := msg.GetValue()
-		if value, ok := value.(canopus.MessagePayload); ok {
-			gi := &Gateway{}
-			err := json.Unmarshal(value.GetBytes(), gi)
-			if err == nil {
-				out <- gi
-			}
-		}
+// Like ListDevices, but gives up when the given context is done.
+func (c *Client) ListDevicesContext(ctx context.Context) (devices []*Device, err error) {
+	deviceIds, err := c.ListDeviceIdsContext(ctx)
+	if err != nil {
+		return
 	}
-}
 
-func (c *Client) observerDevices(in chan canopus.ObserveMessage, out chan *Device) {
-	for msg := range in {
-		value := msg.GetValue()
-		if value, ok := value.(canopus.MessagePayload); ok {
-			dd := &Device{}
-			err := json.Unmarshal(value.GetBytes(), dd)
-			if err == nil {
-				out <- dd
-			}
-		}
+	c.log(LevelDebug, "Enumerating devices", Field{"count", len(deviceIds)})
+	items, err := c.enumerate(ctx, deviceIds, func(ctx context.Context, id uint32) (interface{}, error) {
+		return c.GetDeviceContext(ctx, id)
+	})
+	devices = make([]*Device, len(items))
+	for i, item := range items {
+		devices[i] = item.(*Device)
 	}
-}
-
-// Observe the gateway for changes. These changes will be sent over
-// the channel returned by GatewayEvents, which must be called first.
-func (c *Client) ObserveGateway() error {
-	return c.observe(uriGatewayInfo)
-}
-
-// Returns a channel over which any updates to any devices will be
-// sent, see ObserveDevice.
-func (c *Client) GatewayEvents() <-chan *Gateway {
-	out := make(chan *Gateway)
-	in := make(chan canopus.ObserveMessage)
-	go c.connection.Observe(in)
-	go c.observerGateway(in, out)
-	return out
-}
-
-// Observe the given device, i.e., any changes through other channels
-// (such as a remote) will be sent over the channel returned by
-// DeviceEvents, which must be called first.
-func (c *Client) ObserveDevice(deviceId uint32) error {
-	uri := fmt.Sprintf("%s/%d", uriDevices, deviceId)
-	return c.observe(uri)
-}
-
-// Returns a channel over which any updates to any devices will be
-// sent, see ObserveDevice.
-func (c *Client) DeviceEvents() <-chan *Device {
-	out := make(chan *Device)
-	in := make(chan canopus.ObserveMessage)
-	go c.connection.Observe(in)
-	go c.observerDevices(in, out)
-	return out
+	return devices, err
 }
@@ -0,0 +1,649 @@
+package sladdfri
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zubairhamed/canopus"
+)
+
+func TestGetDevice(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001/65537", map[string]interface{}{
+		"9001": "Living room",
+		"9003": 65537,
+		"5750": 2,
+		"3311": []map[string]interface{}{{"5850": 1, "5851": 254}},
+	}))
+	c := NewClientWithTransport(transport)
+
+	device, err := c.GetDevice(65537)
+	assert.NoError(err)
+	assert.Equal(uint32(65537), device.ID)
+	assert.Equal("Living room", device.Name)
+	assert.Equal(Light, device.Type)
+	assert.Equal(uint8(254), device.LightControl[0].Dim)
+}
+
+func TestGetDeviceNotFound(t *testing.T) {
+	assert := assert.New(t)
+	c := NewClientWithTransport(NewMemoryTransport())
+
+	_, err := c.GetDevice(65537)
+	assert.ErrorIs(err, ErrNotFound)
+	var coapErr *CoAPError
+	if assert.ErrorAs(err, &coapErr) {
+		assert.Equal("/15001/65537", coapErr.URI)
+		assert.Equal(canopus.CoapCodeNotFound, coapErr.Code)
+	}
+	assert.EqualError(err, "GET /15001/65537: 4.04 Not Found")
+}
+
+func TestServerError(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	transport.Handle(canopus.Delete, "/15004/131073", func(Request) *Response {
+		return &Response{Code: canopus.CoapCodeServiceUnavailable}
+	})
+	c := NewClientWithTransport(transport)
+
+	err := c.RemoveGroup(131073)
+	assert.ErrorIs(err, ErrServer)
+	assert.False(errors.Is(err, ErrNotFound))
+}
+
+func TestListDevices(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001", []uint32{65540, 65537, 65539, 65538}))
+	for _, id := range []uint32{65537, 65539, 65540} {
+		assert.NoError(transport.SetResource(fmt.Sprintf("/15001/%d", id), map[string]interface{}{
+			"9003": id,
+			"5750": 2,
+		}))
+	}
+	c := NewClientWithTransport(transport)
+	c.Concurrency = 2
+
+	devices, err := c.ListDevices()
+	var ids []uint32
+	for _, device := range devices {
+		ids = append(ids, device.ID)
+	}
+	assert.Equal([]uint32{65537, 65539, 65540}, ids)
+
+	var listErr *ListError
+	assert.True(errors.As(err, &listErr))
+	assert.Len(listErr.Items, 1)
+	assert.Equal(uint32(65538), listErr.Items[0].ID)
+	assert.True(errors.Is(err, ErrNotFound))
+}
+
+func TestListDevicesConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	var ids []string
+	for id := 65537; id < 65537+16; id++ {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	conn := newFakeDTLSConn(func(c *fakeDTLSConn, req canopus.Message) {
+		// A gateway takes a while to answer.
+		time.Sleep(20 * time.Millisecond)
+		if req.GetURIPath() == "/15001" {
+			c.reply(req, "["+strings.Join(ids, ",")+"]")
+			return
+		}
+		c.reply(req, `{"9003":`+strings.TrimPrefix(req.GetURIPath(), "/15001/")+`}`)
+	})
+	transport := newDTLSTransport(conn)
+	defer transport.Close()
+	c := NewClientWithTransport(transport)
+	c.RateLimiter = nil
+
+	list := func(concurrency int) time.Duration {
+		c.Concurrency = concurrency
+		start := time.Now()
+		devices, err := c.ListDevices()
+		assert.NoError(err)
+		assert.Len(devices, 16)
+		return time.Since(start)
+	}
+	sequential := list(1)
+	concurrent := list(8)
+	assert.True(concurrent < sequential/2, "sequential %s, concurrent %s", sequential, concurrent)
+}
+
+func TestSetDevice(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001/65537", map[string]interface{}{
+		"9003": 65537,
+		"5750": 2,
+		"3":    map[string]interface{}{"1": "TRADFRI bulb E27 WS opal 980lm"},
+		"3311": []map[string]interface{}{{"5709": 30138, "5710": 26909, "5711": 370}},
+	}))
+	transport.Accept(canopus.Put, "/15001/65537")
+	c := NewClientWithTransport(transport)
+
+	assert.NoError(c.SetDevice(65537, NewLightUpdate().On()))
+	assert.NoError(c.SetDevice(65537, NewLightUpdate().Off().Dim(0).Kelvin(2700).Transition(time.Second)))
+	requests := transport.Requests()
+	assert.Len(requests, 3)
+	assert.Equal(canopus.Get, requests[0].Method)
+	assert.JSONEq(`{"3311":[{"5850":1}]}`, string(requests[1].Payload))
+	assert.JSONEq(`{"3311":[{"5850":0,"5851":0,"5711":370,"5712":10}]}`, string(requests[2].Payload))
+
+	err := c.SetDevice(65537, NewLightUpdate().XY(30000, 26000))
+	assert.True(errors.Is(err, ErrUnsupported))
+	assert.False(errors.Is(err, ErrInvalid))
+	var validation *ValidationError
+	assert.True(errors.As(err, &validation))
+	assert.Len(validation.Fields, 2)
+	assert.Equal("ColorX", validation.Fields[0].Field)
+	var unsupported *UnsupportedError
+	assert.True(errors.As(err, &unsupported))
+	assert.Equal(uint32(65537), unsupported.Device)
+	assert.Equal(CapColorXY, unsupported.Missing)
+	assert.Len(transport.Requests(), 3)
+}
+
+func TestValidation(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001/65537", map[string]interface{}{
+		"9003": 65537,
+		"5750": 2,
+		"3":    map[string]interface{}{"1": "TRADFRI bulb E27 CWS opal 600lm"},
+	}))
+	c := NewClientWithTransport(transport)
+
+	err := c.SetDevice(65537, NewLightUpdate().Dim(255).Mireds(100).XY(70000, 0).Color("zzz"))
+	var validation *ValidationError
+	assert.True(errors.As(err, &validation))
+	assert.True(errors.Is(err, ErrInvalid))
+	var fields []string
+	for _, field := range validation.Fields {
+		fields = append(fields, field.Field)
+	}
+	assert.Equal([]string{"Dim", "Mireds", "Mireds", "Color", "ColorX"}, fields)
+	assert.True(errors.Is(validation.Fields[2].Err, ErrUnsupported))
+	assert.Equal("Dim: 255 is not in the range [0,254]", validation.Fields[0].Error())
+
+	// Hue goes all the way up to 65535, saturation stops short.
+	err = c.SetDevice(65537, NewLightUpdate().Hue(65535).Sat(65535))
+	assert.True(errors.As(err, &validation))
+	assert.Len(validation.Fields, 1)
+	assert.Equal("ColorSat: 65535 is not in the range [0,65279]", validation.Fields[0].Error())
+	err = c.SetDevice(65537, NewLightUpdate().Hue(65536))
+	assert.True(errors.As(err, &validation))
+	assert.Equal("ColorHue", validation.Fields[0].Field)
+
+	err = c.SetDevice(65537, nil)
+	assert.True(errors.Is(err, ErrInvalid))
+	err = c.SetGroup(131073, nil)
+	assert.True(errors.Is(err, ErrInvalid))
+	err = c.SetBlindPosition(65541, 101)
+	assert.True(errors.Is(err, ErrInvalid))
+	err = c.SetFanMode(65542, 7)
+	assert.True(errors.Is(err, ErrInvalid))
+	err = c.SetFanMode(65542, 13)
+	assert.True(errors.Is(err, ErrInvalid))
+	err = c.RenameMood(1, "")
+	assert.True(errors.Is(err, ErrInvalid))
+	err = c.CreateSmartTask(SmartTask{
+		Type:         WakeUp,
+		TriggerTimes: []TimeInterval{{StartHour: 24, StartMinute: 60}},
+	})
+	assert.True(errors.As(err, &validation))
+	assert.Len(validation.Fields, 2)
+	err = c.UpdateSmartTask(SmartTask{
+		Type:         WakeUp,
+		TriggerTimes: []TimeInterval{{StartHour: 7}},
+	})
+	assert.True(errors.Is(err, ErrInvalid))
+	err = c.UpdateMood(196608, nil)
+	assert.True(errors.Is(err, ErrInvalid))
+
+	// The bulb is no outlet, nor any other kind of device.
+	err = c.SetOutlet(65537, true)
+	var unsupported *UnsupportedError
+	assert.True(errors.As(err, &unsupported))
+	assert.Equal(Outlet, unsupported.Required)
+	assert.Equal(Light, unsupported.Type)
+	assert.Equal("ID: 65537 is of type Light, not Outlet", err.(*ValidationError).Fields[0].Error())
+	assert.True(errors.Is(c.StopBlind(65537), ErrUnsupported))
+	assert.True(errors.Is(c.SetChildLock(65537, true), ErrUnsupported))
+
+	// Only the device itself has been fetched; nothing invalid was sent.
+	assert.Len(transport.Requests(), 1)
+}
+
+func TestCapabilities(t *testing.T) {
+	assert := assert.New(t)
+	device := func(model string, controls ...LightControl) *Device {
+		d := &Device{Type: Light, LightControl: controls}
+		d.Device.ModelNumber = model
+		return d
+	}
+
+	assert.Equal(CapDim, device("TRADFRI bulb E27 W opal 1000lm").Capabilities())
+	assert.Equal(CapDim, device("TRADFRI bulb E27 W opal 1000lm",
+		LightControl{ColorX: 30138, ColorY: 26909}).Capabilities())
+	assert.Equal(CapDim, device("TRADFRI Driver 30W", LightControl{Mireds: 370}).Capabilities())
+	assert.Equal(CapDim|CapColorTemperature, device("TRADFRI bulb GU10 WS 400lm").Capabilities())
+	assert.Equal(CapDim|CapColor, device("TRADFRI bulb E27 CWS opal 600lm").Capabilities())
+	assert.Equal(CapDim|CapColorTemperature, device("GUNNARP panel round").Capabilities())
+	assert.Equal(CapDim|CapColorTemperature, device("Unknown bulb", LightControl{Mireds: 370}).Capabilities())
+	assert.Equal(CapDim|CapColorTemperature, device("TRADFRI bulb E27 WS opal 980lm",
+		LightControl{Mireds: 370, ColorX: 30138, ColorY: 26909}).Capabilities())
+	assert.Equal(Capabilities(0), (&Device{Type: Outlet}).Capabilities())
+}
+
+func TestSetOutlet(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001/65540", map[string]interface{}{
+		"9003": 65540,
+		"5750": 3,
+		"3312": []map[string]interface{}{{"5850": 1, "9003": 0}},
+	}))
+	transport.Accept(canopus.Put, "/15001/65540")
+	c := NewClientWithTransport(transport)
+
+	device, err := c.GetDevice(65540)
+	assert.NoError(err)
+	assert.Equal(Outlet, device.Type)
+	assert.Equal(uint8(1), device.PlugControl[0].Power)
+	assert.Contains(device.String(), "Plug Control Set 0, Power: on")
+
+	assert.NoError(c.SetOutlet(65540, false))
+	assert.JSONEq(`{"3312":[{"5850":0}]}`, string(transport.Requests()[1].Payload))
+}
+
+func TestBlinds(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001/65541", map[string]interface{}{
+		"9003":  65541,
+		"5750":  7,
+		"3":     map[string]interface{}{"9": 87},
+		"15015": []map[string]interface{}{{"5536": 35.5, "9003": 0}},
+	}))
+	transport.Accept(canopus.Put, "/15001/65541")
+	c := NewClientWithTransport(transport)
+
+	device, err := c.GetDevice(65541)
+	assert.NoError(err)
+	assert.Equal(Blind, device.Type)
+	assert.Equal(35.5, device.BlindControl[0].Position)
+	assert.Equal(uint8(87), device.Device.BatteryLevel)
+
+	assert.NoError(c.SetBlindPosition(65541, 100))
+	assert.NoError(c.StopBlind(65541))
+	requests := transport.Requests()
+	assert.JSONEq(`{"15015":[{"5536":100}]}`, string(requests[1].Payload))
+	assert.JSONEq(`{"15015":[{"5523":0}]}`, string(requests[2].Payload))
+}
+
+func TestAirPurifier(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001/65542", map[string]interface{}{
+		"9003": 65542,
+		"5750": 10,
+		"15025": []map[string]interface{}{{
+			"5900": 1, "5902": 1200, "5903": 0, "5904": 259200, "5905": 0,
+			"5906": 0, "5907": 5, "5908": 10, "5909": 4000, "5910": 258000, "9003": 0,
+		}},
+	}))
+	transport.Accept(canopus.Put, "/15001/65542")
+	c := NewClientWithTransport(transport)
+
+	device, err := c.GetDevice(65542)
+	assert.NoError(err)
+	assert.Equal(AirPurifier, device.Type)
+	control := device.AirPurifierControl[0]
+	assert.Equal(FanAuto, control.Mode)
+	assert.Equal(5, control.AirQuality)
+	assert.Equal(258000*time.Minute, control.FilterRemaining())
+
+	assert.NoError(c.SetFanMode(65542, FanOff))
+	assert.NoError(c.SetFanSpeed(65542, 25))
+	assert.NoError(c.SetChildLock(65542, true))
+	assert.NoError(c.SetStatusLEDs(65542, false))
+	requests := transport.Requests()
+	assert.JSONEq(`{"15025":[{"5900":0}]}`, string(requests[1].Payload))
+	assert.JSONEq(`{"15025":[{"5908":25}]}`, string(requests[2].Payload))
+	assert.JSONEq(`{"15025":[{"5905":1}]}`, string(requests[3].Payload))
+	assert.JSONEq(`{"15025":[{"5906":1}]}`, string(requests[4].Payload))
+
+	assert.NoError(c.SetFanMode(65542, 35))
+	assert.Equal("Manual 35", FanMode(35).String())
+	assert.Equal("Unknown", FanMode(13).String())
+}
+
+func TestSetGroup(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	transport.Accept(canopus.Put, "/15004/131073")
+	c := NewClientWithTransport(transport)
+
+	assert.NoError(c.SetGroup(131073, NewLightUpdate().On().Dim(127)))
+	assert.NoError(c.SetGroup(131073, NewLightUpdate().XY(30015, 26870).Transition(time.Second)))
+	requests := transport.Requests()
+	assert.JSONEq(`{"5850":1,"5851":127}`, string(requests[0].Payload))
+	assert.JSONEq(`{"5709":30015,"5710":26870,"5712":10}`, string(requests[1].Payload))
+	assert.Error(c.SetGroup(131073, NewLightUpdate().ResetOnTime()))
+
+	group := &Group{Mireds: 454}
+	assert.Contains(group.String(), fmt.Sprintf("Color: %dK \n", MiredToKelvin(454)))
+	assert.NotContains(group.String(), "#")
+}
+
+func TestAddDevicesToGroup(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001", []uint32{65537, 65538}))
+	assert.NoError(transport.SetResource("/15004/131073", map[string]interface{}{
+		"9003": 131073,
+		"9018": map[string]interface{}{"15002": map[string]interface{}{"9003": []uint32{65537, 65538}}},
+	}))
+	transport.Accept(canopus.Put, "/15004/add")
+	c := NewClientWithTransport(transport)
+
+	group, err := c.AddDevicesToGroup(131073, []uint32{65538})
+	assert.NoError(err)
+	assert.Equal([]uint32{65537, 65538}, group.AccessoryLink.LinkedItems.DeviceIDs)
+	assert.JSONEq(`{"9003":131073,"9018":{"15002":{"9003":[65538]}}}`, string(transport.Requests()[1].Payload))
+
+	_, err = c.AddDevicesToGroup(131073, []uint32{65539})
+	assert.Error(err)
+}
+
+func TestRemoveDevicesFromGroup(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001", []uint32{65537, 65538}))
+	assert.NoError(transport.SetResource("/15004/131073", map[string]interface{}{
+		"9003": 131073,
+		"9018": map[string]interface{}{"15002": map[string]interface{}{"9003": []uint32{65537, 65538}}},
+	}))
+	transport.Handle(canopus.Put, "/15004/remove", func(Request) *Response {
+		transport.SetResource("/15004/131073", map[string]interface{}{
+			"9003": 131073,
+			"9018": map[string]interface{}{"15002": map[string]interface{}{"9003": []uint32{65537}}},
+		})
+		return &Response{Code: canopus.CoapCodeChanged}
+	})
+	c := NewClientWithTransport(transport)
+
+	group, err := c.RemoveDevicesFromGroup(131073, []uint32{65538})
+	assert.NoError(err)
+	assert.Equal([]uint32{65537}, group.AccessoryLink.LinkedItems.DeviceIDs)
+	assert.Equal("/15004/remove", transport.Requests()[1].URI)
+	assert.JSONEq(`{"9003":131073,"9018":{"15002":{"9003":[65538]}}}`, string(transport.Requests()[1].Payload))
+
+	_, err = c.RemoveDevicesFromGroup(131073, nil)
+	assert.ErrorIs(err, ErrInvalid)
+}
+
+func TestRenameGroup(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15004/131073", map[string]interface{}{"9003": 131073, "9001": "Kitchen"}))
+	transport.Handle(canopus.Put, "/15004/131073", func(req Request) *Response {
+		var payload map[string]interface{}
+		json.Unmarshal(req.Payload, &payload)
+		transport.SetResource("/15004/131073", map[string]interface{}{"9003": 131073, "9001": payload["9001"]})
+		return &Response{Code: canopus.CoapCodeChanged}
+	})
+	c := NewClientWithTransport(transport)
+
+	group, err := c.RenameGroup(131073, "Dining room")
+	assert.NoError(err)
+	assert.Equal("Dining room", group.Name)
+	assert.JSONEq(`{"9001":"Dining room"}`, string(transport.Requests()[0].Payload))
+
+	_, err = c.RenameGroup(131073, "")
+	assert.ErrorIs(err, ErrInvalid)
+	assert.Len(transport.Requests(), 2)
+}
+
+func TestCreateMood(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15005", []uint32{200000}))
+	for id, model := range map[uint32]string{
+		65537: "TRADFRI bulb E27 WS opal 980lm",
+		65538: "TRADFRI bulb E27 W opal 1000lm",
+	} {
+		assert.NoError(transport.SetResource(fmt.Sprintf("/15001/%d", id), map[string]interface{}{
+			"9003": id,
+			"5750": 2,
+			"3":    map[string]interface{}{"1": model},
+		}))
+	}
+	assert.NoError(transport.SetResource("/15005/200000/196608", map[string]interface{}{"9003": 196608}))
+	transport.Accept(canopus.Post, "/15005/200000")
+	transport.Accept(canopus.Put, "/15004/131073")
+	c := NewClientWithTransport(transport)
+
+	assert.NoError(c.CreateMood("Evening", []LightControl{
+		{ID: 65537, Power: 1, Dim: 127, Mireds: 454},
+		{ID: 65538, Power: 0},
+	}))
+	assert.NoError(c.ActivateMood(131073, 196608))
+	requests := transport.Requests()
+	assert.Len(requests, 7)
+	assert.JSONEq(`{"9001":"Evening","9058":1,"15013":[
+		{"9003":65537,"5850":1,"5851":127,"5711":454},
+		{"9003":65538,"5850":0,"5851":0}]}`, string(requests[3].Payload))
+	assert.Equal("/15005/200000/196608", requests[5].URI)
+	assert.JSONEq(`{"9039":196608,"5850":1}`, string(requests[6].Payload))
+
+	// Unknown moods are not sent to the group.
+	assert.True(errors.Is(c.ActivateMood(131073, 196609), ErrNotFound))
+	assert.Len(transport.Requests(), 9)
+
+	// The white bulb has no color temperature, and both bulbs have been
+	// seen before.
+	err := c.UpdateMood(196608, []LightControl{{ID: 65538, Power: 1, Mireds: 454}})
+	assert.True(errors.Is(err, ErrUnsupported))
+	err = c.CreateMood("Party", []LightControl{{ID: 65537, Power: 1, ColorX: 30000, ColorY: 26000}})
+	assert.True(errors.Is(err, ErrUnsupported))
+	assert.Len(transport.Requests(), 9)
+}
+
+func TestSmartTasks(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15010", []uint32{317094}))
+	transport.Handle(canopus.Get, "/15010/317094", func(Request) *Response {
+		return &Response{Code: canopus.CoapCodeContent, Payload: []byte(`{"9003":317094,"9040":3,"5850":1,
+			"9041":31,"9044":[{"9046":6,"9047":45}],"9042":{"5850":1,"15013":[{"9003":65537,"5851":254,"9203":18000}]}}`)}
+	})
+	transport.Accept(canopus.Put, "/15010/317094")
+	c := NewClientWithTransport(transport)
+
+	tasks, err := c.ListSmartTasks()
+	assert.NoError(err)
+	assert.Len(tasks, 1)
+	assert.Equal(WakeUp, tasks[0].Type)
+	assert.Equal(WorkDays, tasks[0].RepeatDays)
+	assert.True(tasks[0].RepeatDays.Contains(time.Friday))
+	assert.False(tasks[0].RepeatDays.Contains(time.Sunday))
+	assert.Equal("Mon,Tue,Wed,Thu,Fri", tasks[0].RepeatDays.String())
+	assert.Equal(6*time.Hour+45*time.Minute, tasks[0].TriggerTimes[0].Start())
+	assert.Equal(uint32(65537), tasks[0].StartAction.Lights[0].ID)
+
+	assert.NoError(c.EnableSmartTask(317094, false))
+	assert.JSONEq(`{"5850":0}`, string(transport.Requests()[2].Payload))
+
+	// A task ending at midnight still carries its end time.
+	assert.NoError(c.UpdateSmartTask(SmartTask{
+		ID:           317094,
+		Type:         NotAtHome,
+		TriggerTimes: []TimeInterval{{StartHour: 18}},
+	}))
+	assert.Contains(string(transport.Requests()[3].Payload), `"9044":[{"9046":18,"9047":0,"9048":0,"9049":0}]`)
+	assert.Equal(Saturday|Sunday, WeekdaysOf(time.Sunday, time.Saturday))
+}
+
+func TestRemoveGroup(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	c := NewClientWithTransport(transport)
+
+	c.RemoveGroup(131073)
+	requests := transport.Requests()
+	assert.Len(requests, 1)
+	assert.Equal(canopus.Delete, requests[0].Method)
+	assert.Equal("/15004/131073", requests[0].URI)
+}
+
+func TestSubscribeDevice(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	c := NewClientWithTransport(transport)
+
+	devices, err := c.SubscribeDevice(65537)
+	assert.NoError(err)
+	gateway, err := c.SubscribeGateway()
+	assert.NoError(err)
+	assert.True(transport.Observed("/15001/65537"))
+	assert.True(transport.Observed("/15011/15012"))
+
+	assert.NoError(transport.Notify("/15011/15012", map[string]interface{}{"9081": "gw"}))
+	assert.NoError(transport.Notify("/15001/65537", map[string]interface{}{"9003": 65537}))
+	assert.Equal("gw", (<-gateway.Updates()).ID)
+	assert.Equal(uint32(65537), (<-devices.Updates()).ID)
+
+	assert.NoError(devices.Cancel())
+	assert.False(transport.Observed("/15001/65537"))
+	_, ok := <-devices.Updates()
+	assert.False(ok)
+	_, ok = <-devices.Done()
+	assert.False(ok)
+	assert.True(transport.Observed("/15011/15012"))
+}
+
+func TestSubscribeGroupAndMood(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15005", []uint32{200000}))
+	c := NewClientWithTransport(transport)
+
+	groups, err := c.SubscribeGroup(131073)
+	assert.NoError(err)
+	moods, err := c.SubscribeMood(196608)
+	assert.NoError(err)
+
+	assert.NoError(transport.Notify("/15005/200000/196608", map[string]interface{}{"9003": 196608}))
+	assert.NoError(transport.Notify("/15004/131073", map[string]interface{}{"9003": 131073, "9039": 196608}))
+	assert.Equal(uint32(196608), (<-moods.Updates()).ID)
+	assert.Equal(uint32(196608), (<-groups.Updates()).MoodID)
+}
+
+// A MemoryTransport that takes a while to register observations, and counts
+// them.
+type slowObserveTransport struct {
+	*MemoryTransport
+	observes int32
+}
+
+func (t *slowObserveTransport) Observe(ctx context.Context, uri string) error {
+	atomic.AddInt32(&t.observes, 1)
+	time.Sleep(10 * time.Millisecond)
+	return t.MemoryTransport.Observe(ctx, uri)
+}
+
+func TestConcurrentSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+	transport := &slowObserveTransport{MemoryTransport: NewMemoryTransport()}
+	c := NewClientWithTransport(transport)
+	c.RateLimiter = nil
+
+	var wg sync.WaitGroup
+	subs := make([]*DeviceSubscription, 4)
+	for i := range subs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			subs[i], err = c.SubscribeDevice(65537)
+			assert.NoError(err)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(&transport.observes))
+
+	// Cancelling the last subscription while a new one is being set up
+	// leaves the new one observed.
+	for _, sub := range subs[1:] {
+		assert.NoError(sub.Cancel())
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := c.SubscribeDevice(65537)
+		assert.NoError(err)
+	}()
+	assert.NoError(subs[0].Cancel())
+	<-done
+	assert.True(transport.Observed("/15001/65537"))
+}
+
+func TestContextCancellation(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	c := NewClientWithTransport(transport)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GetDeviceContext(ctx, 65537)
+	assert.Equal(context.Canceled, err)
+	assert.Empty(transport.Requests())
+
+	// Fetch one device at a time, so that cancelling while fetching the
+	// first prevents fetching the second.
+	c.Concurrency = 1
+	ctx, cancel = context.WithCancel(context.Background())
+	transport.SetResource("/15001", []uint32{65537, 65538})
+	transport.Handle(canopus.Get, "/15001/65537", func(Request) *Response {
+		cancel()
+		return &Response{Code: canopus.CoapCodeContent, Payload: []byte(`{"9003":65537}`)}
+	})
+	_, err = c.ListDevicesContext(ctx)
+	assert.Equal(context.Canceled, err)
+	assert.Len(transport.Requests(), 2)
+}
+
+func TestCloseUnconnected(t *testing.T) {
+	c := NewClient("localhost", "gatewaycode")
+	assert.NoError(t, c.Close())
+}
+
+func TestRequestUnconnected(t *testing.T) {
+	assert := assert.New(t)
+	c := NewClient("localhost", "gatewaycode")
+
+	_, err := c.ListDevices()
+	assert.Equal(errNotConnected, err)
+	_, err = c.Subscribe("/15001/65537")
+	assert.Equal(errNotConnected, err)
+
+	// Heartbeats fail the same way.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	for range c.Supervise(ctx, SuperviseOptions{HeartbeatInterval: time.Millisecond}) {
+	}
+}
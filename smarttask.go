@@ -0,0 +1,296 @@
+package sladdfri
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	uriSmartTasks = "/15010"
+)
+
+// The kind of a smart task.
+type SmartTaskType uint8
+
+const (
+	// Switches lights on and off at random to make it seem someone is home.
+	NotAtHome SmartTaskType = 1
+
+	// Switches lights off at a given time.
+	LightsOff SmartTaskType = 2
+
+	// Gradually brightens lights at a given time.
+	WakeUp SmartTaskType = 3
+)
+
+func (t SmartTaskType) String() string {
+	switch t {
+	case NotAtHome:
+		return "Not at home"
+	case LightsOff:
+		return "Lights off"
+	case WakeUp:
+		return "Wake up"
+	default:
+		return "Unknown"
+	}
+}
+
+// A set of days of the week on which a smart task is repeated.
+type Weekdays uint8
+
+const (
+	Monday    Weekdays = 1
+	Tuesday   Weekdays = 2
+	Wednesday Weekdays = 4
+	Thursday  Weekdays = 8
+	Friday    Weekdays = 16
+	Saturday  Weekdays = 32
+	Sunday    Weekdays = 64
+
+	Weekend  = Saturday | Sunday
+	WorkDays = Monday | Tuesday | Wednesday | Thursday | Friday
+	EveryDay = WorkDays | Weekend
+)
+
+// Returns the set of the given days of the week.
+func WeekdaysOf(days ...time.Weekday) Weekdays {
+	var w Weekdays
+	for _, day := range days {
+		w |= weekday(day)
+	}
+	return w
+}
+
+// Reports whether the given day of the week is in this set.
+func (w Weekdays) Contains(day time.Weekday) bool {
+	return w&weekday(day) != 0
+}
+
+func (w Weekdays) String() string {
+	var days []string
+	for day := time.Monday; ; day = (day + 1) % 7 {
+		if w.Contains(day) {
+			days = append(days, day.String()[:3])
+		}
+		if day == time.Sunday {
+			break
+		}
+	}
+	return strings.Join(days, ",")
+}
+
+// The gateway starts the week on Monday, time.Weekday on Sunday.
+func weekday(day time.Weekday) Weekdays {
+	return 1 << uint((day+6)%7)
+}
+
+// A TimeInterval specifies when a smart task is triggered, in the local time
+// of the gateway. Only NotAtHome tasks use the end of the interval.
+type TimeInterval struct {
+	// The hour at which the task starts, in the range [0,23].
+	StartHour uint8 `json:"9046"`
+
+	// The minute at which the task starts, in the range [0,59].
+	StartMinute uint8 `json:"9047"`
+
+	// The hour at which the task ends, in the range [0,23].
+	EndHour uint8 `json:"9048"`
+
+	// The minute at which the task ends, in the range [0,59].
+	EndMinute uint8 `json:"9049"`
+}
+
+// Returns the start of this interval as an offset from midnight.
+func (i TimeInterval) Start() time.Duration {
+	return time.Duration(i.StartHour)*time.Hour + time.Duration(i.StartMinute)*time.Minute
+}
+
+// Returns the end of this interval as an offset from midnight.
+func (i TimeInterval) End() time.Duration {
+	return time.Duration(i.EndHour)*time.Hour + time.Duration(i.EndMinute)*time.Minute
+}
+
+func (i TimeInterval) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", i.StartHour, i.StartMinute, i.EndHour, i.EndMinute)
+}
+
+// The settings a single light is brought to when a smart task starts.
+type SmartTaskLight struct {
+	// Numeric identifier of the bulb.
+	ID uint32 `json:"9003"`
+
+	// Dimmer value in the range [0,254].
+	Dim uint8 `json:"5851"`
+
+	// The duration of the transition to the new settings, in tenths of a
+	// second.
+	TransitionTime int `json:"9203"`
+}
+
+// The action taken when a smart task starts.
+type StartAction struct {
+	// Whether the lights are switched on or off.
+	Power uint8 `json:"5850"`
+
+	// The settings of the individual lights.
+	Lights []SmartTaskLight `json:"15013"`
+}
+
+// The SmartTask struct holds all information related to a smart task, i.e. a
+// schedule, on the Trådfri gateway.
+type SmartTask struct {
+	// Numeric identifier of this smart task.
+	ID uint32 `json:"9003,omitempty"`
+
+	// The time at which this smart task was created.
+	CreatedAt int64 `json:"9002,omitempty"`
+
+	// The kind of this smart task, see SmartTaskType.
+	Type SmartTaskType `json:"9040"`
+
+	// Whether this smart task is enabled.
+	Enabled uint8 `json:"5850"`
+
+	// The days of the week on which this smart task is repeated. Zero
+	// means the task is triggered once.
+	RepeatDays Weekdays `json:"9041"`
+
+	// When this smart task is triggered.
+	TriggerTimes []TimeInterval `json:"9044"`
+
+	// What this smart task does when it is triggered.
+	StartAction StartAction `json:"9042"`
+}
+
+func (t *SmartTask) String() string {
+	createdAt := time.Unix(t.CreatedAt, 0)
+	s := fmt.Sprintf("ID: %d Type: %s Created: %s\n", t.ID, t.Type, createdAt.Format(time.RFC1123))
+	s += fmt.Sprintf("Enabled: %d Repeat: %s Triggers: %v\n", t.Enabled, t.RepeatDays, t.TriggerTimes)
+
+	d := "[ "
+	for _, light := range t.StartAction.Lights {
+		d += fmt.Sprintf("%d ", light.ID)
+	}
+	d += "]"
+	s += fmt.Sprintf("Power: %d Devices: %s\n", t.StartAction.Power, d)
+	return s
+}
+
+// The data sent to the gateway in a request to enable or disable a smart
+// task.
+type EnableSmartTaskRequest struct {
+	// Whether the smart task is enabled.
+	Enabled uint8 `json:"5850"`
+}
+
+// Gets the given smart task's information, see SmartTask.
+func (c *Client) GetSmartTask(id uint32) (*SmartTask, error) {
+	return c.GetSmartTaskContext(context.Background(), id)
+}
+
+// Like GetSmartTask, but gives up when the given context is done.
+func (c *Client) GetSmartTaskContext(ctx context.Context, id uint32) (*SmartTask, error) {
+	uri := fmt.Sprintf("%s/%d", uriSmartTasks, id)
+	var desc SmartTask
+	err := c.getRequest(ctx, uri, &desc)
+	if err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+// Lists all the smart tasks on the gateway, ordered by ID. If some smart tasks
+// cannot be fetched, the others are returned along with a *ListError.
+func (c *Client) ListSmartTasks() ([]*SmartTask, error) {
+	return c.ListSmartTasksContext(context.Background())
+}
+
+// Like ListSmartTasks, but gives up when the given context is done.
+func (c *Client) ListSmartTasksContext(ctx context.Context) ([]*SmartTask, error) {
+	var taskIds []uint32
+	err := c.getRequest(ctx, uriSmartTasks, &taskIds)
+	if err != nil {
+		return nil, err
+	}
+
+	c.log(LevelDebug, "Enumerating smart tasks", Field{"count", len(taskIds)})
+	items, err := c.enumerate(ctx, taskIds, func(ctx context.Context, id uint32) (interface{}, error) {
+		return c.GetSmartTaskContext(ctx, id)
+	})
+	tasks := make([]*SmartTask, len(items))
+	for i, item := range items {
+		tasks[i] = item.(*SmartTask)
+	}
+	return tasks, err
+}
+
+// Adds the given smart task to the gateway. Its ID and CreatedAt are
+// ignored.
+func (c *Client) CreateSmartTask(task SmartTask) error {
+	return c.CreateSmartTaskContext(context.Background(), task)
+}
+
+// Like CreateSmartTask, but gives up when the given context is done.
+func (c *Client) CreateSmartTaskContext(ctx context.Context, task SmartTask) error {
+	var v validator
+	task.validate(&v)
+	err := v.err()
+	if err != nil {
+		return err
+	}
+
+	task.ID = 0
+	task.CreatedAt = 0
+	return c.postRequest(ctx, uriSmartTasks, task)
+}
+
+// Changes the smart task, whose identifier matches the one from the given
+// SmartTask, to the given SmartTask.
+func (c *Client) UpdateSmartTask(task SmartTask) error {
+	return c.UpdateSmartTaskContext(context.Background(), task)
+}
+
+// Like UpdateSmartTask, but gives up when the given context is done.
+func (c *Client) UpdateSmartTaskContext(ctx context.Context, task SmartTask) error {
+	var v validator
+	if task.ID == 0 {
+		v.invalid("ID", task.ID, "must identify a smart task")
+	}
+	task.validate(&v)
+	err := v.err()
+	if err != nil {
+		return err
+	}
+
+	uri := fmt.Sprintf("%s/%d", uriSmartTasks, task.ID)
+	task.CreatedAt = 0
+	return c.putRequest(ctx, uri, task)
+}
+
+// Enables or disables the given smart task.
+func (c *Client) EnableSmartTask(id uint32, enabled bool) error {
+	return c.EnableSmartTaskContext(context.Background(), id, enabled)
+}
+
+// Like EnableSmartTask, but gives up when the given context is done.
+func (c *Client) EnableSmartTaskContext(ctx context.Context, id uint32, enabled bool) error {
+	uri := fmt.Sprintf("%s/%d", uriSmartTasks, id)
+	var payload EnableSmartTaskRequest
+	if enabled {
+		payload.Enabled = 1
+	}
+	return c.putRequest(ctx, uri, payload)
+}
+
+// Removes the given smart task from the gateway.
+func (c *Client) RemoveSmartTask(id uint32) error {
+	return c.RemoveSmartTaskContext(context.Background(), id)
+}
+
+// Like RemoveSmartTask, but gives up when the given context is done.
+func (c *Client) RemoveSmartTaskContext(ctx context.Context, id uint32) error {
+	return c.deleteRequest(ctx, fmt.Sprintf("%s/%d", uriSmartTasks, id))
+}
@@ -0,0 +1,381 @@
+package sladdfri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A Subscription delivers the notifications sent by the gateway for a single
+// observed resource. Subscriptions to the same resource share a single
+// observation, which is deregistered once the last of them is cancelled.
+type Subscription struct {
+	// The URI of the observed resource.
+	URI string
+
+	client        *Client
+	notifications chan Notification
+
+	// Closed when the subscription is cancelled.
+	done       chan struct{}
+	cancelOnce sync.Once
+}
+
+// Subscribes to changes of the resource at the given URI, e.g. "/15001/65537".
+// Prefer the typed variants such as SubscribeDevice.
+func (c *Client) Subscribe(uri string) (*Subscription, error) {
+	return c.SubscribeContext(context.Background(), uri)
+}
+
+// Like Subscribe, but gives up when the given context is done.
+func (c *Client) SubscribeContext(ctx context.Context, uri string) (*Subscription, error) {
+	if c.currentTransport() == nil {
+		return nil, errNotConnected
+	}
+
+	unlock := c.lockObservation(uri)
+	defer unlock()
+
+	c.mu.RLock()
+	observed := len(c.subscriptions[uri]) > 0
+	c.mu.RUnlock()
+
+	if !observed {
+		observeCtx, cancel := c.withTimeout(ctx)
+		defer cancel()
+		err := c.wait(observeCtx)
+		if err != nil {
+			return nil, err
+		}
+		err = c.currentTransport().Observe(observeCtx, uri)
+		if err != nil {
+			c.checkFailure(ctx, err)
+			return nil, err
+		}
+	}
+
+	s := &Subscription{
+		URI:           uri,
+		client:        c,
+		notifications: make(chan Notification),
+		done:          make(chan struct{}),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string][]*Subscription)
+	}
+	c.subscriptions[uri] = append(c.subscriptions[uri], s)
+	if c.forwarding == nil {
+		c.forwarding = make(chan struct{})
+		go c.forward(c.transport.Notifications(), c.forwarding)
+	}
+	return s, nil
+}
+
+// Returns the channel over which the notifications for this subscription are
+// sent. It must be drained: a subscription that is not read from blocks the
+// delivery of notifications to all others.
+func (s *Subscription) Notifications() <-chan Notification {
+	return s.notifications
+}
+
+// Returns a channel that is closed once this subscription is cancelled.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Cancels this subscription. If it is the last subscription to its resource,
+// the observation is deregistered with the gateway.
+func (s *Subscription) Cancel() error {
+	return s.CancelContext(context.Background())
+}
+
+// Like Cancel, but gives up when the given context is done.
+func (s *Subscription) CancelContext(ctx context.Context) error {
+	var err error
+	s.cancelOnce.Do(func() {
+		close(s.done)
+		err = s.client.cancelObservation(ctx, s)
+	})
+	return err
+}
+
+// Removes the given subscription, deregistering the observation of its URI
+// if it was the last one.
+func (c *Client) cancelObservation(ctx context.Context, s *Subscription) error {
+	unlock := c.lockObservation(s.URI)
+	defer unlock()
+	if !c.unsubscribe(s) {
+		return nil
+	}
+
+	cancelCtx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	err := c.wait(cancelCtx)
+	if err != nil {
+		return err
+	}
+	err = c.currentTransport().CancelObserve(cancelCtx, s.URI)
+	c.checkFailure(ctx, err)
+	return err
+}
+
+// A lock on the observation of a single URI, see lockObservation.
+type observationLock struct {
+	mu sync.Mutex
+
+	// The number of callers holding or waiting for the lock.
+	refs int
+}
+
+// Locks the observation of the given URI, returning the function that unlocks
+// it. Subscribing and cancelling hold the lock from checking whether the URI
+// is observed until the subscriptions are updated, so that two subscribers do
+// not both register an observation, and a cancellation does not deregister
+// the observation a new subscriber is about to rely on.
+func (c *Client) lockObservation(uri string) func() {
+	c.mu.Lock()
+	if c.observing == nil {
+		c.observing = make(map[string]*observationLock)
+	}
+	l, ok := c.observing[uri]
+	if !ok {
+		l = &observationLock{}
+		c.observing[uri] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.observing, uri)
+		}
+	}
+}
+
+// Removes the given subscription, returning whether it was the last one for
+// its URI.
+func (c *Client) unsubscribe(s *Subscription) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	subscriptions := c.subscriptions[s.URI]
+	for i, other := range subscriptions {
+		if other == s {
+			subscriptions = append(subscriptions[:i], subscriptions[i+1:]...)
+			break
+		}
+	}
+	if len(subscriptions) > 0 {
+		c.subscriptions[s.URI] = subscriptions
+		return false
+	}
+	delete(c.subscriptions, s.URI)
+	return true
+}
+
+// Routes the notifications of a transport to the subscriptions for their URI,
+// until stop is closed.
+func (c *Client) forward(in <-chan Notification, stop <-chan struct{}) {
+	for {
+		select {
+		case msg := <-in:
+			// Be lenient towards transports reporting paths without
+			// the leading slash.
+			msg.URI = "/" + strings.TrimLeft(msg.URI, "/")
+
+			c.mu.RLock()
+			subscriptions := make([]*Subscription, len(c.subscriptions[msg.URI]))
+			copy(subscriptions, c.subscriptions[msg.URI])
+			c.mu.RUnlock()
+
+			for _, s := range subscriptions {
+				select {
+				case s.notifications <- msg:
+				case <-s.done:
+				case <-stop:
+					return
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Subscribes to the resource at the given URI, decoding every notification
+// into a new T. The decoded values are sent over the returned channel, which
+// is closed once the subscription is cancelled. Notifications that cannot be
+// decoded are dropped.
+func subscribeDecoded[T any](ctx context.Context, c *Client, uri string) (*subscriptionHandle, chan *T, error) {
+	s, err := c.SubscribeContext(ctx, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updates := make(chan *T)
+	go func() {
+		defer close(updates)
+		for {
+			select {
+			case msg := <-s.notifications:
+				value := new(T)
+				if json.Unmarshal(msg.Payload, value) != nil {
+					continue
+				}
+				select {
+				case updates <- value:
+				case <-s.done:
+					return
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return &subscriptionHandle{s}, updates, nil
+}
+
+// A DeviceSubscription delivers the changes of a single device.
+type DeviceSubscription struct {
+	*subscriptionHandle
+	updates chan *Device
+}
+
+// Subscribes to changes of the given device, e.g. through a remote.
+func (c *Client) SubscribeDevice(id uint32) (*DeviceSubscription, error) {
+	return c.SubscribeDeviceContext(context.Background(), id)
+}
+
+// Like SubscribeDevice, but gives up when the given context is done.
+func (c *Client) SubscribeDeviceContext(ctx context.Context, id uint32) (*DeviceSubscription, error) {
+	h, updates, err := subscribeDecoded[Device](ctx, c, fmt.Sprintf("%s/%d", uriDevices, id))
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceSubscription{h, updates}, nil
+}
+
+// Returns the channel over which the new state of the device is sent. It is
+// closed once the subscription is cancelled.
+func (s *DeviceSubscription) Updates() <-chan *Device {
+	return s.updates
+}
+
+// A GatewaySubscription delivers the changes of the gateway.
+type GatewaySubscription struct {
+	*subscriptionHandle
+	updates chan *Gateway
+}
+
+// Subscribes to changes of the gateway.
+func (c *Client) SubscribeGateway() (*GatewaySubscription, error) {
+	return c.SubscribeGatewayContext(context.Background())
+}
+
+// Like SubscribeGateway, but gives up when the given context is done.
+func (c *Client) SubscribeGatewayContext(ctx context.Context) (*GatewaySubscription, error) {
+	h, updates, err := subscribeDecoded[Gateway](ctx, c, uriGatewayInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &GatewaySubscription{h, updates}, nil
+}
+
+// Returns the channel over which the new state of the gateway is sent. It is
+// closed once the subscription is cancelled.
+func (s *GatewaySubscription) Updates() <-chan *Gateway {
+	return s.updates
+}
+
+// A GroupSubscription delivers the changes of a single group.
+type GroupSubscription struct {
+	*subscriptionHandle
+	updates chan *Group
+}
+
+// Subscribes to changes of the given group, such as its power, dim level and
+// active mood.
+func (c *Client) SubscribeGroup(id uint32) (*GroupSubscription, error) {
+	return c.SubscribeGroupContext(context.Background(), id)
+}
+
+// Like SubscribeGroup, but gives up when the given context is done.
+func (c *Client) SubscribeGroupContext(ctx context.Context, id uint32) (*GroupSubscription, error) {
+	h, updates, err := subscribeDecoded[Group](ctx, c, fmt.Sprintf("%s/%d", uriGroups, id))
+	if err != nil {
+		return nil, err
+	}
+	return &GroupSubscription{h, updates}, nil
+}
+
+// Returns the channel over which the new state of the group is sent. It is
+// closed once the subscription is cancelled.
+func (s *GroupSubscription) Updates() <-chan *Group {
+	return s.updates
+}
+
+// A MoodSubscription delivers the changes of a single mood.
+type MoodSubscription struct {
+	*subscriptionHandle
+	updates chan *Mood
+}
+
+// Subscribes to changes of the given mood.
+func (c *Client) SubscribeMood(id uint32) (*MoodSubscription, error) {
+	return c.SubscribeMoodContext(context.Background(), id)
+}
+
+// Like SubscribeMood, but gives up when the given context is done.
+func (c *Client) SubscribeMoodContext(ctx context.Context, id uint32) (*MoodSubscription, error) {
+	parent, err := c.moodParent(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h, updates, err := subscribeDecoded[Mood](ctx, c, fmt.Sprintf("%s/%d/%d", uriMoods, *parent, id))
+	if err != nil {
+		return nil, err
+	}
+	return &MoodSubscription{h, updates}, nil
+}
+
+// Returns the channel over which the new state of the mood is sent. It is
+// closed once the subscription is cancelled.
+func (s *MoodSubscription) Updates() <-chan *Mood {
+	return s.updates
+}
+
+// Exposes the parts of a Subscription that make sense for typed
+// subscriptions, whose raw notifications are consumed internally.
+type subscriptionHandle struct {
+	s *Subscription
+}
+
+// Returns the URI of the observed resource.
+func (h *subscriptionHandle) URI() string {
+	return h.s.URI
+}
+
+// Returns a channel that is closed once this subscription is cancelled.
+func (h *subscriptionHandle) Done() <-chan struct{} {
+	return h.s.Done()
+}
+
+// Cancels this subscription, see Subscription.Cancel.
+func (h *subscriptionHandle) Cancel() error {
+	return h.s.Cancel()
+}
+
+// Like Cancel, but gives up when the given context is done.
+func (h *subscriptionHandle) CancelContext(ctx context.Context) error {
+	return h.s.CancelContext(ctx)
+}
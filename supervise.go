@@ -0,0 +1,222 @@
+package sladdfri
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zubairhamed/canopus"
+)
+
+const (
+	defaultHeartbeatInterval = 30 * time.Second
+	defaultMinBackoff        = time.Second
+	defaultMaxBackoff        = time.Minute
+)
+
+// The state of a supervised connection, see Client.Supervise.
+type ConnectionState uint8
+
+const (
+	// The connection to the gateway has failed.
+	Disconnected ConnectionState = 0
+
+	// A new connection to the gateway is being established.
+	Connecting ConnectionState = 1
+
+	// The connection to the gateway has been (re-)established, and all
+	// subscriptions have been registered again.
+	Connected ConnectionState = 2
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "Disconnected"
+	case Connecting:
+		return "Connecting"
+	case Connected:
+		return "Connected"
+	default:
+		return "Unknown"
+	}
+}
+
+// SuperviseOptions configure a supervised connection. Zero values are
+// replaced by sensible defaults.
+type SuperviseOptions struct {
+	// How often the gateway is contacted to check that the connection is
+	// still alive. Defaults to 30 seconds.
+	HeartbeatInterval time.Duration
+
+	// The delay before the second reconnection attempt. The delay doubles
+	// after every failed attempt, up to MaxBackoff. Defaults to 1 second.
+	MinBackoff time.Duration
+
+	// The maximum delay between reconnection attempts. Defaults to 1
+	// minute.
+	MaxBackoff time.Duration
+}
+
+// Supervises the connection of this client until the given context is done.
+// Whenever a request fails because of the connection, or a periodic heartbeat
+// goes unanswered, the client reconnects using its current credentials,
+// backing off exponentially between attempts, and registers the observations
+// of all subscriptions again. The client must have been connected before.
+//
+// Every state transition is sent over the returned channel, which must be
+// drained and which is closed once the context is done.
+func (c *Client) Supervise(ctx context.Context, opts SuperviseOptions) <-chan ConnectionState {
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = defaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = defaultMaxBackoff
+		if opts.MaxBackoff < opts.MinBackoff {
+			opts.MaxBackoff = opts.MinBackoff
+		}
+	}
+
+	states := make(chan ConnectionState)
+	go c.supervise(ctx, opts, states)
+	return states
+}
+
+func (c *Client) supervise(ctx context.Context, opts SuperviseOptions, states chan<- ConnectionState) {
+	defer close(states)
+
+	ticker := time.NewTicker(opts.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.failureSignal():
+		case <-ticker.C:
+			// A failing heartbeat signals a failure like any other
+			// request, which is picked up in the next iteration.
+			c.request(ctx, uriGatewayInfo, canopus.Get, nil)
+			continue
+		}
+
+		if !c.reconnect(ctx, opts, states) {
+			return
+		}
+	}
+}
+
+// Reconnects until it succeeds or the context is done, in which case false is
+// returned.
+func (c *Client) reconnect(ctx context.Context, opts SuperviseOptions, states chan<- ConnectionState) bool {
+	if !sendState(ctx, states, Disconnected) {
+		return false
+	}
+
+	backoff := opts.MinBackoff
+	for {
+		if !sendState(ctx, states, Connecting) {
+			return false
+		}
+
+		err := c.redial(ctx)
+		if err == nil {
+			break
+		}
+		c.log(LevelWarn, "Reconnecting failed", Field{"error", err}, Field{"backoff", backoff})
+
+		if sleep(ctx, backoff) != nil {
+			return false
+		}
+		backoff *= 2
+		if backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+
+	// Requests failing during the outage have left their mark; the new
+	// connection is fine.
+	select {
+	case <-c.failureSignal():
+	default:
+	}
+	c.log(LevelInfo, "Reconnected to gateway")
+	return sendState(ctx, states, Connected)
+}
+
+// Dials a new transport and registers the observations of all subscriptions
+// on it before putting it in use.
+func (c *Client) redial(ctx context.Context) error {
+	address := c.address()
+	dialCtx, cancel := c.withTimeout(ctx)
+	creds := c.Credentials()
+	transport, err := c.dial(dialCtx, address, creds.Identity, creds.PSK)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	uris := make([]string, 0, len(c.subscriptions))
+	for uri := range c.subscriptions {
+		uris = append(uris, uri)
+	}
+	c.mu.RUnlock()
+
+	for _, uri := range uris {
+		observeCtx, cancel := c.withTimeout(ctx)
+		err = c.wait(observeCtx)
+		if err == nil {
+			err = transport.Observe(observeCtx, uri)
+		}
+		cancel()
+		if err != nil {
+			transport.Close()
+			return err
+		}
+	}
+
+	c.setTransport(transport)
+	return nil
+}
+
+func sendState(ctx context.Context, states chan<- ConnectionState, state ConnectionState) bool {
+	select {
+	case states <- state:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (c *Client) failureSignal() chan struct{} {
+	c.failuresOnce.Do(func() {
+		c.failures = make(chan struct{}, 1)
+	})
+	return c.failures
+}
+
+// Signals the supervisor, if any, when the given error returned by the
+// transport indicates a broken connection.
+func (c *Client) checkFailure(ctx context.Context, err error) {
+	if !isTransportFailure(ctx, err) {
+		return
+	}
+	select {
+	case c.failureSignal() <- struct{}{}:
+	default:
+	}
+}
+
+// Reports whether the given error is caused by the connection with the
+// gateway rather than by the gateway's answer or by the caller giving up.
+func isTransportFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var coapErr *CoAPError
+	return !errors.As(err, &coapErr)
+}
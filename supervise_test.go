@@ -0,0 +1,56 @@
+package sladdfri
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuperviseReconnects(t *testing.T) {
+	assert := assert.New(t)
+	first := NewMemoryTransport()
+	second := NewMemoryTransport()
+	assert.NoError(second.SetResource("/15001/65537", map[string]interface{}{"9003": 65537}))
+
+	dials := 0
+	c := NewClientWithTransport(first)
+	c.Dial = func(ctx context.Context, address, identity, psk string) (Transport, error) {
+		dials++
+		if dials == 1 {
+			return nil, errors.New("Gateway is rebooting")
+		}
+		return second, nil
+	}
+
+	sub, err := c.SubscribeDevice(65537)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	states := c.Supervise(ctx, SuperviseOptions{MinBackoff: time.Millisecond})
+
+	first.Close()
+	_, err = c.GetDevice(65537)
+	assert.Error(err)
+
+	assert.Equal(Disconnected, <-states)
+	assert.Equal(Connecting, <-states)
+	assert.Equal(Connecting, <-states)
+	assert.Equal(Connected, <-states)
+	assert.True(second.Observed("/15001/65537"))
+
+	device, err := c.GetDevice(65537)
+	assert.NoError(err)
+	assert.Equal(uint32(65537), device.ID)
+
+	assert.NoError(second.Notify("/15001/65537", map[string]interface{}{"9003": 65537}))
+	device = <-sub.Updates()
+	assert.Equal(uint32(65537), device.ID)
+
+	cancel()
+	_, ok := <-states
+	assert.False(ok)
+}
@@ -0,0 +1,69 @@
+package sladdfri
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zubairhamed/canopus"
+)
+
+// A Request is a single CoAP request sent to the gateway over a Transport.
+type Request struct {
+	// The CoAP method of this request: canopus.Get, Post, Put or Delete.
+	Method canopus.CoapCode
+
+	// The URI of the requested resource, e.g. "/15001/65536".
+	URI string
+
+	// The JSON encoded payload of this request, if any.
+	Payload []byte
+}
+
+// A Response is the gateway's reply to a Request.
+type Response struct {
+	// The CoAP response code, e.g. canopus.CoapCodeContent.
+	Code canopus.CoapCode
+
+	// The JSON encoded payload of this response, if any.
+	Payload []byte
+}
+
+// A Notification is sent by the gateway whenever an observed resource changes.
+type Notification struct {
+	// The URI of the resource that changed.
+	URI string
+
+	// The JSON encoded new state of the resource.
+	Payload []byte
+}
+
+// Transport is the connection over which a Client talks to the gateway. The
+// default implementation, returned by DialDTLS, speaks CoAP over DTLS. A
+// MemoryTransport can be used in its place in tests.
+type Transport interface {
+	// Sends the given request as a confirmable message and waits for the
+	// gateway's response, or until the given context is done.
+	Send(ctx context.Context, req Request) (*Response, error)
+
+	// Registers an observation of the given URI. Changes to the resource
+	// are sent over the channel returned by Notifications.
+	Observe(ctx context.Context, uri string) error
+
+	// Cancels an observation previously registered through Observe.
+	CancelObserve(ctx context.Context, uri string) error
+
+	// Returns the channel over which notifications for all observed
+	// resources are sent.
+	Notifications() <-chan Notification
+
+	// Closes the connection to the gateway.
+	Close() error
+}
+
+// A DialFunc connects to the gateway at the given address using the given
+// identity and preshared key. DialDTLS is the default.
+type DialFunc func(ctx context.Context, address, identity, psk string) (Transport, error)
+
+var errTransportClosed = errors.New("Transport is closed")
+
+var errNotConnected = errors.New("Not connected")
@@ -0,0 +1,326 @@
+package sladdfri
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"github.com/zubairhamed/canopus"
+)
+
+const (
+	// The largest message read from the gateway.
+	maxMessageSize = 1500
+
+	// The length of the tokens identifying exchanges and observations.
+	tokenLength = 8
+
+	// The number of notifications buffered before the transport stops
+	// reading from the gateway until they are consumed.
+	notificationBuffer = 64
+)
+
+// The parts of a canopus.Connection used by a dtlsTransport. Only raw
+// messages are read and written, so that a single reader can route every
+// response to its request and every notification to its observation.
+type dtlsConn interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Close() error
+}
+
+// dtlsTransport is a Transport speaking CoAP over DTLS to a real gateway.
+type dtlsTransport struct {
+	conn dtlsConn
+
+	// Closed when the transport is closed.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// Protects pending, tokens and uris.
+	mu sync.Mutex
+
+	// The exchanges awaiting their response, by token.
+	pending map[string]chan canopus.Message
+
+	// Tokens of the active observations, by URI.
+	tokens map[string]string
+
+	// URIs of the active observations, by token. Notifications are
+	// responses to the observe request, so they only carry its token.
+	uris map[string]string
+
+	notifications chan Notification
+}
+
+// Connects to the gateway at the given address using the given identity and
+// preshared key, giving up when the given context is done.
+func DialDTLS(ctx context.Context, address, identity, psk string) (Transport, error) {
+	var conn canopus.Connection
+	err := runContext(ctx, func() error {
+		var err error
+		conn, err = canopus.DialDTLS(address, identity, psk)
+		return err
+	}, func() {
+		conn.Close()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newDTLSTransport(conn), nil
+}
+
+// Creates a new dtlsTransport over the given connection and starts reading
+// from it.
+func newDTLSTransport(conn dtlsConn) *dtlsTransport {
+	t := &dtlsTransport{
+		conn:          conn,
+		closed:        make(chan struct{}),
+		pending:       make(map[string]chan canopus.Message),
+		tokens:        make(map[string]string),
+		uris:          make(map[string]string),
+		notifications: make(chan Notification, notificationBuffer),
+	}
+	go t.receive()
+	return t
+}
+
+// Runs fn, returning early with the context's error when the context is done
+// first. Canopus has no notion of cancellation, so fn keeps running in the
+// background; if it later succeeds, abandon is called to clean up after it.
+func runContext(ctx context.Context, fn func() error, abandon func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if abandon != nil {
+			go func() {
+				if <-done == nil {
+					abandon()
+				}
+			}()
+		}
+		return ctx.Err()
+	}
+}
+
+// Returns a new random token.
+func newToken() ([]byte, error) {
+	token := make([]byte, tokenLength)
+	_, err := rand.Read(token)
+	return token, err
+}
+
+// Sends the given message carrying the given token, and waits for the
+// response with the same token, or until the context is done. Exchanges may
+// overlap: the receiving goroutine hands every response to the exchange
+// awaiting its token. A response arriving after its exchange gave up is
+// dropped.
+func (t *dtlsTransport) exchange(ctx context.Context, msg canopus.Message, token []byte) (canopus.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	msg.SetToken(token)
+	data, err := canopus.MessageToBytes(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make(chan canopus.Message, 1)
+	t.mu.Lock()
+	t.pending[string(token)] = responses
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, string(token))
+		t.mu.Unlock()
+	}()
+
+	select {
+	case <-t.closed:
+		return nil, errTransportClosed
+	default:
+	}
+	_, err = t.conn.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-responses:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.closed:
+		return nil, errTransportClosed
+	}
+}
+
+// Converts the given canopus message to a Response.
+func toResponse(msg canopus.Message) *Response {
+	resp := &Response{Code: msg.GetCode()}
+	if payload := msg.GetPayload(); payload != nil {
+		resp.Payload = payload.GetBytes()
+	}
+	return resp
+}
+
+func (t *dtlsTransport) Send(ctx context.Context, r Request) (*Response, error) {
+	switch r.Method {
+	case canopus.Get, canopus.Post, canopus.Put, canopus.Delete:
+		// Valid method.
+	default:
+		return nil, fmt.Errorf("Invalid CoAP message type: %d", r.Method)
+	}
+
+	req := canopus.NewRequest(canopus.MessageConfirmable, r.Method)
+	req.SetRequestURI(r.URI)
+	if r.Payload != nil {
+		req.SetPayload(r.Payload)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := t.exchange(ctx, req.GetMessage(), token)
+	if err != nil {
+		return nil, err
+	}
+	return toResponse(msg), nil
+}
+
+func (t *dtlsTransport) Observe(ctx context.Context, uri string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	req := canopus.NewRequest(canopus.MessageConfirmable, canopus.Get)
+	req.SetRequestURI(uri)
+	req.GetMessage().AddOption(canopus.OptionObserve, 0)
+
+	// Register the observation before sending the request, so that no
+	// notification following the response is lost. The response itself
+	// goes to the exchange, which is awaiting the same token.
+	t.mu.Lock()
+	delete(t.uris, t.tokens[uri])
+	t.tokens[uri] = string(token)
+	t.uris[string(token)] = uri
+	t.mu.Unlock()
+
+	msg, err := t.exchange(ctx, req.GetMessage(), token)
+	if err == nil {
+		err = checkResponse(Request{Method: canopus.Get, URI: uri}, toResponse(msg))
+	}
+	if err != nil {
+		t.mu.Lock()
+		if t.tokens[uri] == string(token) {
+			delete(t.tokens, uri)
+		}
+		delete(t.uris, string(token))
+		t.mu.Unlock()
+	}
+	return err
+}
+
+func (t *dtlsTransport) CancelObserve(ctx context.Context, uri string) error {
+	t.mu.Lock()
+	token, ok := t.tokens[uri]
+	delete(t.tokens, uri)
+	delete(t.uris, token)
+	t.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("Not observing %s", uri)
+	}
+
+	req := canopus.NewRequest(canopus.MessageConfirmable, canopus.Get)
+	req.SetRequestURI(uri)
+	req.GetMessage().AddOption(canopus.OptionObserve, 1)
+	_, err := t.exchange(ctx, req.GetMessage(), []byte(token))
+	return err
+}
+
+func (t *dtlsTransport) Notifications() <-chan Notification {
+	return t.notifications
+}
+
+// Reads every message sent by the gateway, handing responses to the exchange
+// awaiting their token and notifications to the channel returned by
+// Notifications, until the connection fails or the transport is closed.
+func (t *dtlsTransport) receive() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			t.Close()
+			return
+		}
+		msg, err := canopus.BytesToMessage(buf[:n])
+		if err != nil {
+			// Not a CoAP message.
+			continue
+		}
+		if msg.GetMessageType() == canopus.MessageConfirmable {
+			t.acknowledge(msg)
+		}
+		if msg.GetCode() == 0 {
+			// An empty acknowledgement; the response follows
+			// separately.
+			continue
+		}
+
+		token := string(msg.GetToken())
+		t.mu.Lock()
+		responses, pending := t.pending[token]
+		delete(t.pending, token)
+		uri, observed := t.uris[token]
+		t.mu.Unlock()
+
+		switch {
+		case pending:
+			responses <- msg
+		case observed:
+			notification := Notification{URI: uri}
+			if payload := msg.GetPayload(); payload != nil {
+				notification.Payload = payload.GetBytes()
+			}
+			select {
+			case t.notifications <- notification:
+			case <-t.closed:
+				return
+			}
+		default:
+			// A late response to an abandoned exchange, or a late
+			// notification for a cancelled observation.
+		}
+	}
+}
+
+// Acknowledges the given confirmable message, so that the gateway does not
+// send it again.
+func (t *dtlsTransport) acknowledge(msg canopus.Message) {
+	ack := canopus.NewMessage(canopus.MessageAcknowledgment, 0, msg.GetMessageId())
+	data, err := canopus.MessageToBytes(ack)
+	if err == nil {
+		t.conn.Write(data)
+	}
+}
+
+func (t *dtlsTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,174 @@
+package sladdfri
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zubairhamed/canopus"
+)
+
+// A dtlsConn standing in for a gateway. Every request written to it is
+// answered concurrently through handle; acknowledgements are dropped.
+type fakeDTLSConn struct {
+	handle func(req canopus.Message)
+
+	in        chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeDTLSConn(handle func(c *fakeDTLSConn, req canopus.Message)) *fakeDTLSConn {
+	c := &fakeDTLSConn{
+		in:     make(chan []byte),
+		closed: make(chan struct{}),
+	}
+	c.handle = func(req canopus.Message) {
+		handle(c, req)
+	}
+	return c
+}
+
+func (c *fakeDTLSConn) Read(b []byte) (int, error) {
+	select {
+	case data := <-c.in:
+		return copy(b, data), nil
+	case <-c.closed:
+		return 0, io.EOF
+	}
+}
+
+func (c *fakeDTLSConn) Write(b []byte) (int, error) {
+	msg, err := canopus.BytesToMessage(b)
+	if err != nil {
+		return 0, err
+	}
+	if msg.GetMessageType() != canopus.MessageAcknowledgment {
+		go c.handle(msg)
+	}
+	return len(b), nil
+}
+
+func (c *fakeDTLSConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// Sends a message carrying the given token and payload to the client.
+func (c *fakeDTLSConn) send(msgType uint8, id uint16, token []byte, payload string) {
+	msg := canopus.NewMessage(msgType, canopus.CoapCodeContent, id)
+	msg.SetToken(token)
+	msg.SetPayload(canopus.NewBytesPayload([]byte(payload)))
+	data, err := canopus.MessageToBytes(msg)
+	if err != nil {
+		panic(err)
+	}
+	select {
+	case c.in <- data:
+	case <-c.closed:
+	}
+}
+
+// Answers the given request with the given payload.
+func (c *fakeDTLSConn) reply(req canopus.Message, payload string) {
+	c.send(canopus.MessageAcknowledgment, req.GetMessageId(), req.GetToken(), payload)
+}
+
+func TestDTLSTransportRoutesResponsesByToken(t *testing.T) {
+	assert := assert.New(t)
+	first := make(chan canopus.Message, 1)
+	conn := newFakeDTLSConn(func(c *fakeDTLSConn, req canopus.Message) {
+		if req.GetURIPath() == "/15001/65537" {
+			// Hold this request until the other one is answered.
+			first <- req
+			return
+		}
+		c.reply(req, `{"9003":65538}`)
+		c.reply(<-first, `{"9003":65537}`)
+	})
+	transport := newDTLSTransport(conn)
+	defer transport.Close()
+
+	var wg sync.WaitGroup
+	for _, uri := range []string{"/15001/65537", "/15001/65538"} {
+		wg.Add(1)
+		go func(uri string) {
+			defer wg.Done()
+			resp, err := transport.Send(context.Background(), Request{Method: canopus.Get, URI: uri})
+			if assert.NoError(err) {
+				assert.JSONEq(`{"9003":`+uri[len("/15001/"):]+`}`, string(resp.Payload))
+			}
+		}(uri)
+	}
+	wg.Wait()
+}
+
+func TestDTLSTransportRoutesNotificationsByToken(t *testing.T) {
+	assert := assert.New(t)
+	var observeToken []byte
+	observed := make(chan struct{})
+	conn := newFakeDTLSConn(func(c *fakeDTLSConn, req canopus.Message) {
+		switch req.GetURIPath() {
+		case "/15001/65537":
+			if observeToken == nil {
+				observeToken = req.GetToken()
+				c.reply(req, `{"9003":65537}`)
+				close(observed)
+			}
+		case "/15011/15012":
+			// Notify before answering, like a gateway may.
+			c.send(canopus.MessageConfirmable, 1000, observeToken, `{"9003":65537,"9001":"Kitchen"}`)
+			c.reply(req, `{"9081":"gw"}`)
+		}
+	})
+	transport := newDTLSTransport(conn)
+	defer transport.Close()
+
+	assert.NoError(transport.Observe(context.Background(), "/15001/65537"))
+	<-observed
+	resp, err := transport.Send(context.Background(), Request{Method: canopus.Get, URI: "/15011/15012"})
+	assert.NoError(err)
+	assert.JSONEq(`{"9081":"gw"}`, string(resp.Payload))
+
+	notification := <-transport.Notifications()
+	assert.Equal("/15001/65537", notification.URI)
+	assert.JSONEq(`{"9003":65537,"9001":"Kitchen"}`, string(notification.Payload))
+}
+
+func TestDTLSTransportOverlapsExchanges(t *testing.T) {
+	assert := assert.New(t)
+	conn := newFakeDTLSConn(func(c *fakeDTLSConn, req canopus.Message) {
+		// A gateway takes a while to answer.
+		time.Sleep(20 * time.Millisecond)
+		c.reply(req, `{}`)
+	})
+	transport := newDTLSTransport(conn)
+	defer transport.Close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := transport.Send(context.Background(), Request{Method: canopus.Get, URI: "/15011/15012"})
+			assert.NoError(err)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	assert.True(elapsed < 80*time.Millisecond, "elapsed %s", elapsed)
+
+	// An abandoned exchange leaves the transport usable.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err := transport.Send(ctx, Request{Method: canopus.Get, URI: "/15011/15012"})
+	assert.Equal(context.DeadlineExceeded, err)
+	_, err = transport.Send(context.Background(), Request{Method: canopus.Get, URI: "/15011/15012"})
+	assert.NoError(err)
+}
@@ -0,0 +1,171 @@
+package sladdfri
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/zubairhamed/canopus"
+)
+
+// A MemoryHandler answers a request sent to a MemoryTransport.
+type MemoryHandler func(req Request) *Response
+
+type memoryRoute struct {
+	method canopus.CoapCode
+	uri    string
+}
+
+// MemoryTransport is an in-memory Transport intended for tests. Requests are
+// answered by the handler registered for their method and URI; requests
+// without a handler are answered with 4.04 Not Found. Every request is
+// recorded so that tests can inspect what a Client sent.
+type MemoryTransport struct {
+	// Protects all fields below.
+	mu sync.Mutex
+
+	handlers map[memoryRoute]MemoryHandler
+	observed map[string]bool
+	requests []Request
+	closed   bool
+
+	notifications chan Notification
+}
+
+// Creates a new, empty MemoryTransport.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		handlers:      make(map[memoryRoute]MemoryHandler),
+		observed:      make(map[string]bool),
+		notifications: make(chan Notification, 64),
+	}
+}
+
+// Registers the handler for requests with the given method and URI.
+func (t *MemoryTransport) Handle(method canopus.CoapCode, uri string, handler MemoryHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[memoryRoute{method, uri}] = handler
+}
+
+// Answers GET requests for the given URI with the JSON encoding of the given
+// value.
+func (t *MemoryTransport) SetResource(uri string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.Handle(canopus.Get, uri, func(Request) *Response {
+		return &Response{Code: canopus.CoapCodeContent, Payload: data}
+	})
+	return nil
+}
+
+// Answers requests with the given method and URI like the gateway answers
+// successful ones: POST with 2.01 Created, DELETE with 2.02 Deleted and
+// anything else with 2.04 Changed.
+func (t *MemoryTransport) Accept(method canopus.CoapCode, uri string) {
+	code := canopus.CoapCodeChanged
+	switch method {
+	case canopus.Post:
+		code = canopus.CoapCodeCreated
+	case canopus.Delete:
+		code = canopus.CoapCodeDeleted
+	}
+	t.Handle(method, uri, func(Request) *Response {
+		return &Response{Code: code}
+	})
+}
+
+// Sends a notification carrying the JSON encoding of the given value for the
+// given URI, which must be observed.
+func (t *MemoryTransport) Notify(uri string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	observed := t.observed[uri]
+	t.mu.Unlock()
+	if !observed {
+		return fmt.Errorf("Not observing %s", uri)
+	}
+	t.notifications <- Notification{URI: uri, Payload: data}
+	return nil
+}
+
+// Returns all requests received so far, in order.
+func (t *MemoryTransport) Requests() []Request {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	requests := make([]Request, len(t.requests))
+	copy(requests, t.requests)
+	return requests
+}
+
+// Returns whether the given URI is currently observed.
+func (t *MemoryTransport) Observed(uri string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.observed[uri]
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, req Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, errTransportClosed
+	}
+	t.requests = append(t.requests, req)
+	handler, ok := t.handlers[memoryRoute{req.Method, req.URI}]
+	t.mu.Unlock()
+
+	if !ok {
+		return &Response{Code: canopus.CoapCodeNotFound}, nil
+	}
+	return handler(req), nil
+}
+
+func (t *MemoryTransport) Observe(ctx context.Context, uri string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return errTransportClosed
+	}
+	t.observed[uri] = true
+	return nil
+}
+
+func (t *MemoryTransport) CancelObserve(ctx context.Context, uri string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.observed[uri] {
+		return fmt.Errorf("Not observing %s", uri)
+	}
+	delete(t.observed, uri)
+	return nil
+}
+
+func (t *MemoryTransport) Notifications() <-chan Notification {
+	return t.notifications
+}
+
+func (t *MemoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return nil
+}
@@ -0,0 +1,417 @@
+package sladdfri
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/zubairhamed/canopus"
+)
+
+// The pseudo-methods of the events in a Fixture that are not requests.
+const (
+	// The registration of an observation.
+	EventObserve = "OBSERVE"
+
+	// The cancellation of an observation.
+	EventCancelObserve = "CANCEL"
+
+	// A notification sent by the gateway.
+	EventNotify = "NOTIFY"
+)
+
+// A Fixture is a recording of the exchanges between a Client and a gateway,
+// see RecordingTransport and ReplayTransport. It is stored as JSON, so that
+// it can be inspected and edited by hand.
+type Fixture struct {
+	// All events, in the order in which they happened.
+	Events []Event `json:"events"`
+}
+
+// An Event is a single exchange with the gateway in a Fixture.
+type Event struct {
+	// The method of the request, e.g. "GET", or one of EventObserve,
+	// EventCancelObserve and EventNotify.
+	Method string
+
+	// The URI of the requested, observed or changed resource.
+	URI string
+
+	// The payload of the request or notification, if any.
+	Payload []byte
+
+	// The response code of a request, e.g. "2.05".
+	Code string
+
+	// The payload of the response to a request, if any.
+	Response []byte
+
+	// The error returned by the transport instead of a response, if any.
+	Error string
+}
+
+// The stored form of an Event. JSON payloads, which is what the gateway
+// sends, are stored as they are so that they can be read and edited; anything
+// else is stored base64 encoded in a field of its own.
+type fixtureEvent struct {
+	Method        string          `json:"method"`
+	URI           string          `json:"uri"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	PayloadBytes  []byte          `json:"payloadBytes,omitempty"`
+	Code          string          `json:"code,omitempty"`
+	Response      json.RawMessage `json:"response,omitempty"`
+	ResponseBytes []byte          `json:"responseBytes,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	stored := fixtureEvent{
+		Method: e.Method,
+		URI:    e.URI,
+		Code:   e.Code,
+		Error:  e.Error,
+	}
+	stored.Payload, stored.PayloadBytes = splitPayload(e.Payload)
+	stored.Response, stored.ResponseBytes = splitPayload(e.Response)
+	return json.Marshal(stored)
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var stored fixtureEvent
+	err := json.Unmarshal(data, &stored)
+	if err != nil {
+		return err
+	}
+	*e = Event{
+		Method: stored.Method,
+		URI:    stored.URI,
+		Code:   stored.Code,
+		Error:  stored.Error,
+	}
+	e.Payload, err = joinPayload(stored.Payload, stored.PayloadBytes)
+	if err != nil {
+		return err
+	}
+	e.Response, err = joinPayload(stored.Response, stored.ResponseBytes)
+	return err
+}
+
+// Splits the given payload into its stored forms, see fixtureEvent.
+func splitPayload(payload []byte) (json.RawMessage, []byte) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	if json.Valid(payload) {
+		return json.RawMessage(payload), nil
+	}
+	return nil, payload
+}
+
+// Joins the stored forms of a payload, see fixtureEvent. JSON payloads are
+// compacted, undoing the indentation added by Fixture.Save.
+func joinPayload(stored json.RawMessage, raw []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return raw, nil
+	}
+	var buf bytes.Buffer
+	err := json.Compact(&buf, stored)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Reports whether the given payloads are equal, ignoring insignificant
+// whitespace in JSON.
+func equalPayloads(recorded, payload []byte) bool {
+	if bytes.Equal(recorded, payload) {
+		return true
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, payload) != nil {
+		return false
+	}
+	return bytes.Equal(recorded, buf.Bytes())
+}
+
+// Reads the fixture stored at the given path.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Stores this fixture at the given path.
+func (f *Fixture) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// RecordingTransport wraps another Transport, typically one returned by
+// DialDTLS, and records all exchanges going through it into a Fixture:
+//
+//	transport, err := sladdfri.DialDTLS(ctx, "192.168.1.2:5684", identity, psk)
+//	...
+//	recorder := sladdfri.NewRecordingTransport(transport)
+//	client := sladdfri.NewClientWithTransport(recorder)
+//	...
+//	err = recorder.Fixture().Save("testdata/gateway.json")
+type RecordingTransport struct {
+	inner Transport
+
+	// Protects events.
+	mu     sync.Mutex
+	events []Event
+
+	notifications chan Notification
+	pumpOnce      sync.Once
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// Creates a new RecordingTransport recording the exchanges over the given
+// transport.
+func NewRecordingTransport(transport Transport) *RecordingTransport {
+	return &RecordingTransport{
+		inner:         transport,
+		notifications: make(chan Notification),
+		closed:        make(chan struct{}),
+	}
+}
+
+// Returns the exchanges recorded so far.
+func (t *RecordingTransport) Fixture() *Fixture {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	events := make([]Event, len(t.events))
+	copy(events, t.events)
+	return &Fixture{Events: events}
+}
+
+// Records the given event, returning its index so that it can be completed
+// once the outcome is known. Recording requests when they are sent rather
+// than when they are answered keeps the notifications they cause after them.
+func (t *RecordingTransport) record(e Event) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+	return len(t.events) - 1
+}
+
+func (t *RecordingTransport) complete(i int, resp *Response, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.events[i].Error = err.Error()
+		return
+	}
+	if resp != nil {
+		t.events[i].Code = fixtureCode(resp.Code)
+		t.events[i].Response = resp.Payload
+	}
+}
+
+func (t *RecordingTransport) Send(ctx context.Context, req Request) (*Response, error) {
+	i := t.record(Event{
+		Method:  methodString(req.Method),
+		URI:     req.URI,
+		Payload: req.Payload,
+	})
+	resp, err := t.inner.Send(ctx, req)
+	t.complete(i, resp, err)
+	return resp, err
+}
+
+func (t *RecordingTransport) Observe(ctx context.Context, uri string) error {
+	i := t.record(Event{Method: EventObserve, URI: uri})
+	err := t.inner.Observe(ctx, uri)
+	t.complete(i, nil, err)
+	return err
+}
+
+func (t *RecordingTransport) CancelObserve(ctx context.Context, uri string) error {
+	i := t.record(Event{Method: EventCancelObserve, URI: uri})
+	err := t.inner.CancelObserve(ctx, uri)
+	t.complete(i, nil, err)
+	return err
+}
+
+func (t *RecordingTransport) Notifications() <-chan Notification {
+	t.pumpOnce.Do(func() {
+		go t.pump()
+	})
+	return t.notifications
+}
+
+func (t *RecordingTransport) pump() {
+	in := t.inner.Notifications()
+	for {
+		select {
+		case msg := <-in:
+			t.record(Event{
+				Method:  EventNotify,
+				URI:     msg.URI,
+				Payload: msg.Payload,
+			})
+			select {
+			case t.notifications <- msg:
+			case <-t.closed:
+				return
+			}
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+func (t *RecordingTransport) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.inner.Close()
+	})
+	return err
+}
+
+// Returned by a ReplayTransport for a request that was not recorded.
+var ErrNotRecorded = errors.New("Exchange not recorded")
+
+// ReplayTransport answers requests from a Fixture, so that a Client can be
+// tested against the recorded output of a real gateway without any hardware.
+// Every request is answered by the first unused recorded exchange with the
+// same method, URI and payload, regardless of the order in which requests are
+// sent. The notifications recorded after an exchange are sent once that
+// exchange has been replayed.
+type ReplayTransport struct {
+	// Protects all fields below.
+	mu     sync.Mutex
+	events []Event
+	used   []bool
+	closed bool
+
+	notifications chan Notification
+}
+
+// Creates a new ReplayTransport replaying the given fixture.
+func NewReplayTransport(f *Fixture) *ReplayTransport {
+	var count int
+	for _, e := range f.Events {
+		if e.Method == EventNotify {
+			count++
+		}
+	}
+	return &ReplayTransport{
+		events: f.Events,
+		used:   make([]bool, len(f.Events)),
+
+		// Large enough to never block replaying.
+		notifications: make(chan Notification, count),
+	}
+}
+
+// Returns the recorded exchanges that have not been replayed, excluding
+// notifications.
+func (t *ReplayTransport) Unused() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unused []Event
+	for i, e := range t.events {
+		if !t.used[i] && e.Method != EventNotify {
+			unused = append(unused, e)
+		}
+	}
+	return unused
+}
+
+// Finds the first unused event matching the given one, marks it used and
+// sends the notifications following it.
+func (t *ReplayTransport) replay(ctx context.Context, method, uri string, payload []byte) (*Event, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, errTransportClosed
+	}
+	for i, e := range t.events {
+		if t.used[i] || e.Method != method || e.URI != uri || !equalPayloads(e.Payload, payload) {
+			continue
+		}
+		t.used[i] = true
+		for j := i + 1; j < len(t.events) && t.events[j].Method == EventNotify; j++ {
+			if !t.used[j] {
+				t.used[j] = true
+				t.notifications <- Notification{URI: t.events[j].URI, Payload: t.events[j].Payload}
+			}
+		}
+		if e.Error != "" {
+			return nil, errors.New(e.Error)
+		}
+		return &e, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, method, uri)
+}
+
+func (t *ReplayTransport) Send(ctx context.Context, req Request) (*Response, error) {
+	e, err := t.replay(ctx, methodString(req.Method), req.URI, req.Payload)
+	if err != nil {
+		return nil, err
+	}
+	code, err := parseFixtureCode(e.Code)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Code: code, Payload: e.Response}, nil
+}
+
+func (t *ReplayTransport) Observe(ctx context.Context, uri string) error {
+	_, err := t.replay(ctx, EventObserve, uri, nil)
+	return err
+}
+
+func (t *ReplayTransport) CancelObserve(ctx context.Context, uri string) error {
+	_, err := t.replay(ctx, EventCancelObserve, uri, nil)
+	return err
+}
+
+func (t *ReplayTransport) Notifications() <-chan Notification {
+	return t.notifications
+}
+
+func (t *ReplayTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return nil
+}
+
+// Formats the given code like "2.05".
+func fixtureCode(code canopus.CoapCode) string {
+	return fmt.Sprintf("%d.%02d", codeClass(code), uint8(code)&0x1f)
+}
+
+func parseFixtureCode(s string) (canopus.CoapCode, error) {
+	var class, detail uint8
+	_, err := fmt.Sscanf(s, "%d.%d", &class, &detail)
+	if err != nil || class > 7 || detail > 31 {
+		return 0, fmt.Errorf("Invalid response code %q", s)
+	}
+	return canopus.CoapCode(class<<5 | detail), nil
+}
@@ -0,0 +1,89 @@
+package sladdfri
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	assert := assert.New(t)
+	transport := NewMemoryTransport()
+	assert.NoError(transport.SetResource("/15001/65537", map[string]interface{}{
+		"9001": "Living room",
+		"9003": 65537,
+		"5750": 2,
+		"3311": []map[string]interface{}{{"5850": 1, "5851": 254}},
+	}))
+	assert.NoError(transport.SetResource("/15004", []uint32{131073}))
+	assert.NoError(transport.SetResource("/15004/131073", map[string]interface{}{
+		"9001": "Living room",
+		"9003": 131073,
+	}))
+
+	// Record a session against the gateway.
+	recorder := NewRecordingTransport(transport)
+	c := NewClientWithTransport(recorder)
+	c.RateLimiter = nil
+	device, err := c.GetDevice(65537)
+	assert.NoError(err)
+	groups, err := c.ListGroups()
+	assert.NoError(err)
+	sub, err := c.SubscribeDevice(65537)
+	assert.NoError(err)
+	assert.NoError(transport.Notify("/15001/65537", map[string]interface{}{"9003": 65537, "9001": "Kitchen"}))
+	update := <-sub.Updates()
+	assert.Equal("Kitchen", update.Name)
+	_, err = c.GetDevice(65538)
+	assert.True(errors.Is(err, ErrNotFound))
+
+	path := filepath.Join(t.TempDir(), "gateway.json")
+	assert.NoError(recorder.Fixture().Save(path))
+	fixture, err := LoadFixture(path)
+	assert.NoError(err)
+	assert.Equal(recorder.Fixture(), fixture)
+
+	// Replay it.
+	replay := NewReplayTransport(fixture)
+	c = NewClientWithTransport(replay)
+	c.RateLimiter = nil
+	replayed, err := c.GetDevice(65537)
+	assert.NoError(err)
+	assert.Equal(device, replayed)
+	replayedGroups, err := c.ListGroups()
+	assert.NoError(err)
+	assert.Equal(groups, replayedGroups)
+	sub, err = c.SubscribeDevice(65537)
+	assert.NoError(err)
+	select {
+	case update := <-sub.Updates():
+		assert.Equal("Kitchen", update.Name)
+	case <-time.After(time.Second):
+		t.Fatal("No notification replayed")
+	}
+	_, err = c.GetDevice(65538)
+	assert.True(errors.Is(err, ErrNotFound))
+	assert.Empty(replay.Unused())
+
+	// Exchanges are only replayed once.
+	_, err = c.GetDevice(65537)
+	assert.True(errors.Is(err, ErrNotRecorded))
+}
+
+func TestFixturePayloads(t *testing.T) {
+	assert := assert.New(t)
+	fixture := &Fixture{Events: []Event{
+		{Method: "PUT", URI: "/15001/65537", Payload: []byte(`{"3311":[{"5850":1}]}`), Code: "2.04"},
+		{Method: "POST", URI: "/15011/9063", Payload: []byte(`"quoted"`), Code: "2.01", Response: []byte("not JSON")},
+		{Method: "GET", URI: "/15011/15012", Code: "2.05", Response: []byte{0xff, 0x00}},
+	}}
+
+	path := filepath.Join(t.TempDir(), "payloads.json")
+	assert.NoError(fixture.Save(path))
+	loaded, err := LoadFixture(path)
+	assert.NoError(err)
+	assert.Equal(fixture, loaded)
+}
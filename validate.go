@@ -0,0 +1,269 @@
+package sladdfri
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A FieldError describes a single offending field of a rejected request.
+type FieldError struct {
+	// The name of the field, e.g. "Dim" or "LightControls[1].Mireds".
+	Field string
+
+	// The offending value.
+	Value interface{}
+
+	// Why the value was rejected, e.g. "is not in the range [0,254]".
+	Reason string
+
+	// Either ErrInvalid or an *UnsupportedError.
+	Err error
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %v %s", e.Field, e.Value, e.Reason)
+}
+
+// A ValidationError is returned by a Client method that refuses to send a
+// request to the gateway because of the values in it. It matches ErrInvalid
+// or ErrUnsupported through errors.Is, depending on its fields, and exposes
+// the *UnsupportedError of its first unsupported field through errors.As.
+type ValidationError struct {
+	// All offending fields, in the order they were checked.
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		fields[i] = field.Error()
+	}
+	return "Invalid request: " + strings.Join(fields, "; ")
+}
+
+// Reports whether any of the fields of this error matches the given target.
+func (e *ValidationError) Is(target error) bool {
+	for _, field := range e.Fields {
+		if errors.Is(field.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets target to the first error among the fields of this error that matches
+// it.
+func (e *ValidationError) As(target interface{}) bool {
+	for _, field := range e.Fields {
+		if errors.As(field.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Collects the offending fields of a request.
+type validator struct {
+	fields []FieldError
+}
+
+func (v *validator) invalid(field string, value interface{}, reason string) {
+	v.fields = append(v.fields, FieldError{
+		Field:  field,
+		Value:  value,
+		Reason: reason,
+		Err:    ErrInvalid,
+	})
+}
+
+func (v *validator) unsupported(field string, value interface{}, device uint32, missing Capabilities) {
+	v.fields = append(v.fields, FieldError{
+		Field:  field,
+		Value:  value,
+		Reason: "is not supported by the device",
+		Err:    &UnsupportedError{Device: device, Missing: missing},
+	})
+}
+
+func (v *validator) wrongType(field string, device uint32, actual, required DeviceType) {
+	v.fields = append(v.fields, FieldError{
+		Field:  field,
+		Value:  device,
+		Reason: fmt.Sprintf("is of type %s, not %s", actual, required),
+		Err:    &UnsupportedError{Device: device, Required: required, Type: actual},
+	})
+}
+
+func (v *validator) inRange(field string, value, min, max int) {
+	if value < min || value > max {
+		v.invalid(field, value, fmt.Sprintf("is not in the range [%d,%d]", min, max))
+	}
+}
+
+func (v *validator) notEmpty(field, value string) {
+	if value == "" {
+		v.invalid(field, fmt.Sprintf("%q", value), "must not be empty")
+	}
+}
+
+func (v *validator) color(field, value string) {
+	if _, err := hex.DecodeString(value); len(value) != 6 || err != nil {
+		v.invalid(field, fmt.Sprintf("%q", value), "is not a hex color such as \"f1e0b5\"")
+	}
+}
+
+// Returns a *ValidationError if any field is offending, and nil otherwise.
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+// Checks the values in this update against their documented ranges and,
+// unless caps is nil, against the given capabilities of the given device.
+func (u *LightUpdate) validate(v *validator, device uint32, caps *Capabilities) {
+	if u == nil {
+		v.invalid("LightUpdate", nil, "must not be nil")
+		return
+	}
+
+	supports := func(c Capabilities) bool {
+		return caps == nil || caps.Has(c)
+	}
+
+	if u.dim != nil {
+		v.inRange("Dim", int(*u.dim), DimMin, DimMax)
+		if !supports(CapDim) {
+			v.unsupported("Dim", *u.dim, device, CapDim)
+		}
+	}
+	if u.mireds != nil {
+		v.inRange("Mireds", *u.mireds, MiredMin, MiredMax)
+		if !supports(CapColorTemperature) {
+			v.unsupported("Mireds", *u.mireds, device, CapColorTemperature)
+		}
+	}
+	if u.color != nil {
+		v.color("Color", *u.color)
+		// Lights with either a color temperature or full color accept
+		// hex colors.
+		if !supports(CapColorTemperature) && !supports(CapColorXY) {
+			v.unsupported("Color", *u.color, device, CapColorTemperature)
+		}
+	}
+	for _, xy := range []struct {
+		field string
+		value *int
+	}{{"ColorX", u.colorX}, {"ColorY", u.colorY}} {
+		if xy.value == nil {
+			continue
+		}
+		v.inRange(xy.field, *xy.value, XYMin, XYMax)
+		if !supports(CapColorXY) {
+			v.unsupported(xy.field, *xy.value, device, CapColorXY)
+		}
+	}
+	if u.colorHue != nil {
+		v.inRange("ColorHue", *u.colorHue, HueMin, HueMax)
+		if !supports(CapColorHueSat) {
+			v.unsupported("ColorHue", *u.colorHue, device, CapColorHueSat)
+		}
+	}
+	if u.colorSat != nil {
+		v.inRange("ColorSat", *u.colorSat, SatMin, SatMax)
+		if !supports(CapColorHueSat) {
+			v.unsupported("ColorSat", *u.colorSat, device, CapColorHueSat)
+		}
+	}
+	if u.transition != nil && *u.transition < 0 {
+		v.invalid("TransitionDuration", *u.transition, "must not be negative")
+	}
+}
+
+// Checks the settings of the lights in a mood against their documented ranges
+// and the capabilities of the lights, fetching those not seen before. Zero
+// values mean the setting is left out, see MoodLightControl.
+func (c *Client) validateMoodLights(ctx context.Context, v *validator, lights []LightControl) error {
+	for i, light := range lights {
+		field := fmt.Sprintf("LightControls[%d].", i)
+		v.inRange(field+"Dim", int(light.Dim), DimMin, DimMax)
+		if light.Color != "" {
+			v.color(field+"Color", light.Color)
+		}
+		if light.Mireds != 0 {
+			v.inRange(field+"Mireds", light.Mireds, MiredMin, MiredMax)
+		}
+		v.inRange(field+"ColorX", light.ColorX, XYMin, XYMax)
+		v.inRange(field+"ColorY", light.ColorY, XYMin, XYMax)
+		v.inRange(field+"ColorHue", light.ColorHue, HueMin, HueMax)
+		v.inRange(field+"ColorSat", light.ColorSat, SatMin, SatMax)
+
+		caps, err := c.deviceCapabilities(ctx, light.ID)
+		if err != nil {
+			return err
+		}
+		if light.Dim != 0 && !caps.Has(CapDim) {
+			v.unsupported(field+"Dim", light.Dim, light.ID, CapDim)
+		}
+		if light.Mireds != 0 && !caps.Has(CapColorTemperature) {
+			v.unsupported(field+"Mireds", light.Mireds, light.ID, CapColorTemperature)
+		}
+		if light.Color != "" && !caps.Has(CapColorTemperature) && !caps.Has(CapColorXY) {
+			v.unsupported(field+"Color", light.Color, light.ID, CapColorTemperature)
+		}
+		if (light.ColorX != 0 || light.ColorY != 0) && !caps.Has(CapColorXY) {
+			v.unsupported(field+"ColorX", light.ColorX, light.ID, CapColorXY)
+		}
+		if (light.ColorHue != 0 || light.ColorSat != 0) && !caps.Has(CapColorHueSat) {
+			v.unsupported(field+"ColorHue", light.ColorHue, light.ID, CapColorHueSat)
+		}
+	}
+	return nil
+}
+
+// Checks that the given device is of the given type, fetching the device
+// unless it has been seen before.
+func (c *Client) checkDeviceType(ctx context.Context, id uint32, required DeviceType) error {
+	info, err := c.deviceInfo(ctx, id)
+	if err != nil {
+		return err
+	}
+	var v validator
+	if info.typ != required {
+		v.wrongType("ID", id, info.typ, required)
+	}
+	return v.err()
+}
+
+// Checks the values in this smart task against their documented ranges.
+func (t *SmartTask) validate(v *validator) {
+	switch t.Type {
+	case NotAtHome, LightsOff, WakeUp:
+	default:
+		v.invalid("Type", int(t.Type), "is not a known smart task type")
+	}
+	if t.RepeatDays&^EveryDay != 0 {
+		v.invalid("RepeatDays", int(t.RepeatDays), "contains unknown days")
+	}
+	if len(t.TriggerTimes) == 0 {
+		v.invalid("TriggerTimes", "[]", "must not be empty")
+	}
+	for i, interval := range t.TriggerTimes {
+		field := fmt.Sprintf("TriggerTimes[%d].", i)
+		v.inRange(field+"StartHour", int(interval.StartHour), 0, 23)
+		v.inRange(field+"StartMinute", int(interval.StartMinute), 0, 59)
+		v.inRange(field+"EndHour", int(interval.EndHour), 0, 23)
+		v.inRange(field+"EndMinute", int(interval.EndMinute), 0, 59)
+	}
+	for i, light := range t.StartAction.Lights {
+		field := fmt.Sprintf("StartAction.Lights[%d].", i)
+		v.inRange(field+"Dim", int(light.Dim), DimMin, DimMax)
+		if light.TransitionTime < 0 {
+			v.invalid(field+"TransitionTime", light.TransitionTime, "must not be negative")
+		}
+	}
+}